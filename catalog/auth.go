@@ -0,0 +1,201 @@
+package catalog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys used to authenticate registrations
+const (
+	AUTHORIZATION_KEY = "authorization"
+	IDENTITY_KEY      = "x-catalog-identity"
+	TIMESTAMP_KEY     = "x-catalog-timestamp"
+	SIGNATURE_KEY     = "x-catalog-signature"
+
+	// DEFAULT_MAX_SKEW is how old a signed registration can be if AuthOptions.MaxSkew is not set
+	DEFAULT_MAX_SKEW = 5 * time.Minute
+)
+
+// AuthOptions restricts who can register services in the catalog.
+// A registration is authenticated either with a bearer token in the authorization metadata
+// or with an HMAC signature made by SignRegistration.
+type AuthOptions struct {
+	// Tokens maps bearer tokens to the identity they authenticate
+	Tokens map[string]string
+
+	// Keys maps identities to the shared keys they sign registrations with
+	Keys map[string][]byte
+
+	// ACL maps identities to the service names they may register, as path.Match patterns.
+	// If nil, any authenticated identity can register any name.
+	ACL map[string][]string
+
+	// MaxSkew is how far the timestamp of a signed registration can be from the time of the catalog.
+	// Defaults to DEFAULT_MAX_SKEW.
+	MaxSkew time.Duration
+
+	// mu guards the fields while they are replaced by Update
+	mu sync.RWMutex
+}
+
+// Validate checks that no token or key is empty, since an empty one would let anyone authenticate,
+// e.g. when it is read from an environment variable that is not set
+func (a *AuthOptions) Validate() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for token, identity := range a.Tokens {
+		if token == "" {
+			return fmt.Errorf("token of %s is empty", identity)
+		}
+	}
+	for identity, key := range a.Keys {
+		if len(key) == 0 {
+			return fmt.Errorf("key of %s is empty", identity)
+		}
+	}
+	return nil
+}
+
+// Update replaces the tokens, keys, ACL and skew of a with the ones of other,
+// so the credentials of a running catalog can be changed. a is left unchanged if other is not valid.
+func (a *AuthOptions) Update(other *AuthOptions) error {
+	if err := other.Validate(); err != nil {
+		return err
+	}
+
+	other.mu.RLock()
+	tokens, keys, acl, maxSkew := other.Tokens, other.Keys, other.ACL, other.MaxSkew
+	other.mu.RUnlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Tokens, a.Keys, a.ACL, a.MaxSkew = tokens, keys, acl, maxSkew
+	return nil
+}
+
+// SignRegistration returns the hex encoded HMAC-SHA256 of a registration of svc made by identity at timestamp,
+// which is a unix time in seconds. It is sent along with the identity and timestamp in the metadata of Add.
+func SignRegistration(key []byte, identity string, timestamp int64, svc *protogen.Service) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s\n%s\n%s", identity, timestamp, svc.Name, svc.Address, svc.SocketType)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedRegistrationContext returns ctx with the metadata of a registration of svc signed with key
+func SignedRegistrationContext(ctx context.Context, key []byte, identity string, svc *protogen.Service) context.Context {
+	ts := time.Now().Unix()
+	return metadata.AppendToOutgoingContext(ctx,
+		IDENTITY_KEY, identity,
+		TIMESTAMP_KEY, strconv.FormatInt(ts, 10),
+		SIGNATURE_KEY, SignRegistration(key, identity, ts, svc),
+	)
+}
+
+// authorize authenticates the caller of Add and checks it may register svc
+func (a *AuthOptions) authorize(ctx context.Context, svc *protogen.Service) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	identity, err := a.authenticate(md, svc)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+
+	if !a.allowed(identity, svc.Name) {
+		return status.Errorf(codes.PermissionDenied, "%s may not register %s", identity, svc.Name)
+	}
+	return nil
+}
+
+// authenticate returns the identity of a registration, trying the bearer token first and then the signature
+func (a *AuthOptions) authenticate(md metadata.MD, svc *protogen.Service) (string, error) {
+	if auth := first(md, AUTHORIZATION_KEY); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return "", fmt.Errorf("unsupported authorization scheme")
+		}
+		if token == "" {
+			return "", fmt.Errorf("missing token")
+		}
+
+		for t, identity := range a.Tokens {
+			if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return identity, nil
+			}
+		}
+		return "", fmt.Errorf("invalid token")
+	}
+
+	identity := first(md, IDENTITY_KEY)
+	if identity == "" {
+		return "", fmt.Errorf("missing credentials")
+	}
+
+	key, ok := a.Keys[identity]
+	if !ok || len(key) == 0 {
+		return "", fmt.Errorf("unknown identity %s", identity)
+	}
+
+	ts, err := strconv.ParseInt(first(md, TIMESTAMP_KEY), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp")
+	}
+
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = DEFAULT_MAX_SKEW
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return "", fmt.Errorf("timestamp is too far from the time of the catalog")
+	}
+
+	expected := SignRegistration(key, identity, ts, svc)
+	if !hmac.Equal([]byte(expected), []byte(first(md, SIGNATURE_KEY))) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	return identity, nil
+}
+
+// allowed reports whether identity may register name
+func (a *AuthOptions) allowed(identity, name string) bool {
+	if a.ACL == nil {
+		return true
+	}
+
+	for _, pattern := range a.ACL[identity] {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns the first value of key in md
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
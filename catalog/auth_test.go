@@ -0,0 +1,154 @@
+package catalog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testService = &protogen.Service{Name: "billing", Address: "10.0.0.5:10000", SocketType: protogen.SocketType_TCP}
+
+// incoming turns the outgoing metadata of ctx into the metadata received by the catalog
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func bearer(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AUTHORIZATION_KEY, token))
+}
+
+func TestAuthorizeBearer(t *testing.T) {
+	auth := &AuthOptions{
+		Tokens: map[string]string{"secret": "billing-team"},
+		ACL:    map[string][]string{"billing-team": {"billing*"}},
+	}
+
+	if err := auth.authorize(bearer("Bearer secret"), testService); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ctx  context.Context
+		svc  *protogen.Service
+		code codes.Code
+	}{
+		{bearer("Bearer wrong"), testService, codes.Unauthenticated},
+		{bearer("Basic secret"), testService, codes.Unauthenticated},
+		{context.Background(), testService, codes.Unauthenticated},
+		{bearer("Bearer secret"), &protogen.Service{Name: "payments"}, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		if err := auth.authorize(tt.ctx, tt.svc); status.Code(err) != tt.code {
+			t.Fatalf("expected %s registering %s, got %v", tt.code, tt.svc.Name, err)
+		}
+	}
+}
+
+func TestAuthorizeSignature(t *testing.T) {
+	key := []byte("shared key")
+	auth := &AuthOptions{Keys: map[string][]byte{"billing-team": key}}
+
+	ctx := incoming(SignedRegistrationContext(context.Background(), key, "billing-team", testService))
+	if err := auth.authorize(ctx, testService); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature covers the address, so a registration cannot be redirected
+	moved := &protogen.Service{Name: testService.Name, Address: "10.0.0.6:10000", SocketType: testService.SocketType}
+	if err := auth.authorize(ctx, moved); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a modified registration to be rejected, got %v", err)
+	}
+
+	other := incoming(SignedRegistrationContext(context.Background(), []byte("other key"), "billing-team", testService))
+	if err := auth.authorize(other, testService); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a signature made with another key to be rejected, got %v", err)
+	}
+
+	unknown := incoming(SignedRegistrationContext(context.Background(), key, "payments-team", testService))
+	if err := auth.authorize(unknown, testService); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected an unknown identity to be rejected, got %v", err)
+	}
+}
+
+func TestAuthorizeSignatureSkew(t *testing.T) {
+	key := []byte("shared key")
+	auth := &AuthOptions{Keys: map[string][]byte{"billing-team": key}, MaxSkew: time.Minute}
+
+	signedAt := func(ts int64) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			IDENTITY_KEY, "billing-team",
+			TIMESTAMP_KEY, strconv.FormatInt(ts, 10),
+			SIGNATURE_KEY, SignRegistration(key, "billing-team", ts, testService),
+		))
+	}
+
+	if err := auth.authorize(signedAt(time.Now().Add(-30*time.Second).Unix()), testService); err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []time.Time{time.Now().Add(-2 * time.Minute), time.Now().Add(2 * time.Minute)} {
+		if err := auth.authorize(signedAt(ts.Unix()), testService); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected a registration signed at %s to be rejected, got %v", ts, err)
+		}
+	}
+}
+
+func TestAuthUpdate(t *testing.T) {
+	auth := &AuthOptions{Tokens: map[string]string{"old": "billing-team"}}
+	if err := auth.Update(&AuthOptions{Tokens: map[string]string{"new": "billing-team"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.authorize(bearer("Bearer old"), testService); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the old token to be rejected, got %v", err)
+	}
+	if err := auth.authorize(bearer("Bearer new"), testService); err != nil {
+		t.Fatal(err)
+	}
+
+	var none *AuthOptions
+	if err := none.authorize(context.Background(), testService); err != nil {
+		t.Fatalf("expected registrations to be open without auth, got %v", err)
+	}
+}
+
+func TestEmptyCredentials(t *testing.T) {
+	// Like a config whose ${CATALOG_TOKEN} and ${BILLING_KEY} are not set
+	auth := &AuthOptions{
+		Tokens: map[string]string{"": "ops"},
+		Keys:   map[string][]byte{"billing-team": {}},
+	}
+
+	if err := auth.authorize(bearer("Bearer "), testService); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected an empty bearer token to be rejected, got %v", err)
+	}
+	if err := auth.authorize(incoming(SignedRegistrationContext(context.Background(), nil, "billing-team", testService)), testService); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a registration signed with an empty key to be rejected, got %v", err)
+	}
+
+	if err := auth.Validate(); err == nil {
+		t.Fatal("expected an empty token to be invalid")
+	}
+	if err := (&AuthOptions{Keys: map[string][]byte{"billing-team": nil}}).Validate(); err == nil {
+		t.Fatal("expected an empty key to be invalid")
+	}
+
+	valid := &AuthOptions{Tokens: map[string]string{"secret": "ops"}}
+	if err := valid.Update(auth); err == nil {
+		t.Fatal("expected Update to reject empty credentials")
+	}
+	if err := valid.authorize(bearer("Bearer secret"), testService); err != nil {
+		t.Fatalf("expected the credentials to be kept after a failed update, got %v", err)
+	}
+
+	if _, err := NewServer(store.NewMemCatalogStore(), Options{Address: "127.0.0.1:0", Auth: auth}); err == nil {
+		t.Fatal("expected NewServer to reject empty credentials")
+	}
+}
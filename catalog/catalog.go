@@ -2,66 +2,195 @@ package catalog
 
 import (
 	"context"
-	"fmt"
-	"net"
+	"sort"
+	"sync"
 
 	"github.com/cvhariharan/plugin/catalog/protogen"
 	"github.com/cvhariharan/plugin/store"
+	"go.opentelemetry.io/otel/metric"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type CatalogServer struct {
 	protogen.UnimplementedCatalogServer
 	Impl store.CatalogStore
+
+	// Auth, if set, is required to register services
+	Auth *AuthOptions
+
+	// Origin is recorded in the services registered with this catalog
+	Origin string
+
+	// Peers are asked, in order, for services that are not in Impl.
+	// Lookups forwarded by a peer are not forwarded again.
+	Peers []*Client
+
+	// MeterProvider is used to record the catalog metrics, defaults to the global provider if nil
+	MeterProvider metric.MeterProvider
+
+	events eventBroker
+
+	metricsOnce sync.Once
+	metrics     *catalogMetrics
+	prom        *promMetrics
+	missed      missedNames
+}
+
+// Serve starts the catalog gRPC server on address.
+// opts can be used to set interceptors, credentials or message size limits on the server.
+// Calls are traced and measured with the global OpenTelemetry providers, see Options to set others.
+func Serve(cs store.CatalogStore, address string, opts ...grpc.ServerOption) error {
+	return ServeWithOptions(cs, Options{Address: address, GRPCOptions: opts})
 }
 
-func Serve(cs store.CatalogStore, address string) error {
-	_, _, err := net.SplitHostPort(address)
+// ServeWithOptions starts the catalog server configured by opt and blocks until the gRPC server stops
+func ServeWithOptions(cs store.CatalogStore, opt Options) error {
+	return ServeContext(context.Background(), cs, opt)
+}
+
+// ServeContext is like ServeWithOptions but stops the server gracefully when ctx is done,
+// letting in flight calls finish. It returns nil once the server has stopped.
+func ServeContext(ctx context.Context, cs store.CatalogStore, opt Options) error {
+	srv, err := NewServer(cs, opt)
 	if err != nil {
-		return fmt.Errorf("invalid address format: %w", err)
+		return err
 	}
 
-	var opt []grpc.ServerOption
-	srv := grpc.NewServer(opt...)
-
-	c := &CatalogServer{
-		Impl: cs,
+	if err := srv.Start(); err != nil {
+		return err
 	}
-	protogen.RegisterCatalogServer(srv, c)
-	reflection.Register(srv)
 
-	lis, err := net.Listen("tcp", address)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			srv.GracefulStop()
+		case <-done:
+		}
+	}()
+
+	return srv.Wait()
+}
+
+func (c *CatalogServer) Add(ctx context.Context, req *protogen.Service) (*protogen.Empty, error) {
+	err := c.add(ctx, req)
+	name := metricName(req.Name, err == nil)
+	c.getMetrics().recordRegistration(ctx, name, err == nil)
+	if c.prom != nil {
+		c.prom.recordRegistration(name, err == nil)
+	}
 	if err != nil {
-		return fmt.Errorf("could not start catalog server, could not listen on address: %w", err)
+		return nil, err
 	}
-	return srv.Serve(lis)
+
+	return &protogen.Empty{}, nil
 }
 
-func (c *CatalogServer) Add(ctx context.Context, req *protogen.Service) (*protogen.Empty, error) {
-	var socketType store.SocketType
-	switch req.SocketType {
-	case protogen.SocketType_TCP:
-		socketType = store.TCP
-	case protogen.SocketType_UNIX:
-		socketType = store.UNIX
-	default:
-		return nil, fmt.Errorf("invalid socket type")
+// add validates and authorizes a registration before adding it to the store
+func (c *CatalogServer) add(ctx context.Context, req *protogen.Service) error {
+	if err := validateService(req); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
 	}
 
-	if ok := c.Impl.Add(req.Name, store.ServiceInfo{Address: req.Address, Socket: socketType}); !ok {
-		return nil, fmt.Errorf("failed to add service %s", req.Name)
+	if err := c.Auth.authorize(ctx, req); err != nil {
+		return err
 	}
 
-	return &protogen.Empty{}, nil
+	if store.IsReadOnly(c.Impl) {
+		return status.Errorf(codes.FailedPrecondition, "catalog store is read-only")
+	}
+
+	svcInfo := toServiceInfo(req)
+	svcInfo.Origin = c.Origin
+	if !c.Impl.Add(req.Name, svcInfo) {
+		return status.Errorf(codes.AlreadyExists, "catalog store rejected service %s", req.Name)
+	}
+
+	c.events.publish(&protogen.Event{Type: protogen.EventType_ADDED, Service: fromServiceInfo(req.Name, svcInfo)})
+	return nil
 }
 
 func (c *CatalogServer) Get(ctx context.Context, req *protogen.GetReq) (*protogen.Service, error) {
+	if err := validateName(req.Name); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
 	svcInfo, ok := c.Impl.Get(req.Name)
 	if !ok {
-		return nil, fmt.Errorf("service %s not found", req.Name)
+		svcInfo, ok = c.forward(ctx, req.Name)
+	}
+	// The name is valid but may not exist, so misses only get a bounded number of names
+	name := req.Name
+	if !ok {
+		name = c.missed.label(req.Name)
+	}
+	c.getMetrics().recordLookup(ctx, name, ok)
+	if c.prom != nil {
+		c.prom.recordLookup(name, ok)
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "service %s not found", req.Name)
+	}
+
+	return toService(req.Name, svcInfo)
+}
+
+// List returns every service in the catalog, if the store can enumerate them
+func (c *CatalogServer) List(ctx context.Context, req *protogen.Empty) (*protogen.ServiceList, error) {
+	lister, ok := c.Impl.(store.Lister)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "catalog store does not support listing services")
 	}
 
+	services := lister.List()
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var resp protogen.ServiceList
+	for _, name := range names {
+		svc, err := toService(name, services[name])
+		if err != nil {
+			return nil, err
+		}
+		resp.Services = append(resp.Services, svc)
+	}
+
+	return &resp, nil
+}
+
+// Remove removes a service from the catalog, if the store supports it.
+// With Auth set, the caller has to be allowed to register the service.
+func (c *CatalogServer) Remove(ctx context.Context, req *protogen.GetReq) (*protogen.Empty, error) {
+	if err := validateName(req.Name); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if err := c.Auth.authorize(ctx, &protogen.Service{Name: req.Name}); err != nil {
+		return nil, err
+	}
+
+	remover, ok := c.Impl.(store.Remover)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "catalog store does not support removing services")
+	}
+
+	if !remover.Remove(req.Name) {
+		return nil, status.Errorf(codes.NotFound, "service %s not found", req.Name)
+	}
+
+	c.events.publish(&protogen.Event{Type: protogen.EventType_REMOVED, Service: &protogen.Service{Name: req.Name}})
+
+	return &protogen.Empty{}, nil
+}
+
+// toService converts a service of the store to its protobuf representation
+func toService(name string, svcInfo store.ServiceInfo) (*protogen.Service, error) {
 	var socketType protogen.SocketType
 	switch svcInfo.Socket {
 	case store.TCP:
@@ -69,12 +198,21 @@ func (c *CatalogServer) Get(ctx context.Context, req *protogen.GetReq) (*protoge
 	case store.UNIX:
 		socketType = protogen.SocketType_UNIX
 	default:
-		return nil, fmt.Errorf("invalid socket type")
+		return nil, status.Errorf(codes.Internal, "service %s has invalid socket type %q", name, svcInfo.Socket)
 	}
 
 	return &protogen.Service{
-		Name:       req.Name,
+		Name:       name,
 		Address:    svcInfo.Address,
 		SocketType: socketType,
+		Origin:     svcInfo.Origin,
 	}, nil
 }
+
+// getMetrics lazily creates the metric instruments, since CatalogServer can be created without a constructor
+func (c *CatalogServer) getMetrics() *catalogMetrics {
+	c.metricsOnce.Do(func() {
+		c.metrics = newCatalogMetrics(c.Impl, c.MeterProvider)
+	})
+	return c.metrics
+}
@@ -0,0 +1,214 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"github.com/cvhariharan/plugin/store"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsUseKnownNames(t *testing.T) {
+	c := &CatalogServer{Impl: store.NewMemCatalogStore()}
+	c.prom = newPromMetrics(c.Impl)
+	ctx := context.Background()
+
+	c.Add(ctx, &protogen.Service{Name: "hello", Address: "127.0.0.1:10000", SocketType: protogen.SocketType_TCP})
+	c.Add(ctx, &protogen.Service{Name: "not a valid name!", Address: "127.0.0.1:10000"})
+	c.Get(ctx, &protogen.GetReq{Name: "hello"})
+	c.Get(ctx, &protogen.GetReq{Name: "random-name-1"})
+	c.Get(ctx, &protogen.GetReq{Name: "random-name-2"})
+
+	expected := `
+# HELP catalog_registrations_total Number of services added to the catalog.
+# TYPE catalog_registrations_total counter
+catalog_registrations_total{name="hello",result="success"} 1
+catalog_registrations_total{name="unknown",result="failure"} 1
+# HELP catalog_lookup_misses_total Number of catalog lookups for services that were not found.
+# TYPE catalog_lookup_misses_total counter
+catalog_lookup_misses_total{name="random-name-1"} 1
+catalog_lookup_misses_total{name="random-name-2"} 1
+`
+	if err := testutil.GatherAndCompare(c.prom.registry, strings.NewReader(expected), "catalog_registrations_total", "catalog_lookup_misses_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMissedNamesAreBounded(t *testing.T) {
+	c := &CatalogServer{Impl: store.NewMemCatalogStore()}
+	c.prom = newPromMetrics(c.Impl)
+	ctx := context.Background()
+
+	for i := 0; i < MAX_MISSED_NAMES+10; i++ {
+		c.Get(ctx, &protogen.GetReq{Name: fmt.Sprintf("missing-%d", i)})
+	}
+	c.Get(ctx, &protogen.GetReq{Name: "missing-0"})
+
+	if n := testutil.CollectAndCount(c.prom.misses); n != MAX_MISSED_NAMES+1 {
+		t.Fatalf("expected %d miss series, got %d", MAX_MISSED_NAMES+1, n)
+	}
+	if v := testutil.ToFloat64(c.prom.misses.WithLabelValues("missing-0")); v != 2 {
+		t.Fatalf("expected 2 misses for a recorded name, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.prom.misses.WithLabelValues(UNKNOWN_SERVICE)); v != 10 {
+		t.Fatalf("expected 10 misses past the limit, got %v", v)
+	}
+}
+
+func TestMeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	c := &CatalogServer{
+		Impl:          store.NewMemCatalogStore(),
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	}
+	ctx := context.Background()
+
+	c.Add(ctx, &protogen.Service{Name: "hello", Address: "127.0.0.1:10000", SocketType: protogen.SocketType_TCP})
+	c.Get(ctx, &protogen.GetReq{Name: "hello"})
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := make(map[string]bool)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				if name, ok := dp.Attributes.Value("plugin.name"); !ok || name.AsString() != "hello" {
+					t.Fatalf("expected %s to be recorded for plugin.name hello, got %v", m.Name, dp.Attributes.ToSlice())
+				}
+				recorded[m.Name] = true
+			}
+		}
+	}
+	if !recorded["catalog.registrations"] || !recorded["catalog.lookups"] {
+		t.Fatalf("expected registrations and lookups in the meter provider, got %v", recorded)
+	}
+}
+
+func TestLeaseExpiryIsStreamed(t *testing.T) {
+	lease := store.NewLeaseCatalogStore(store.NewMemCatalogStore(), 50*time.Millisecond)
+	srv, err := NewServer(lease, Options{Address: "127.0.0.1:0", HTTPAddress: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(context.Background())
+
+	c, err := DialWithOptions(srv.Addr().String(), ClientOptions{Cache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	events := make(chan *protogen.Event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.WatchContext(ctx, func(e *protogen.Event) { events <- e })
+	if e := <-events; e.Type != protogen.EventType_SUBSCRIBED {
+		t.Fatalf("expected SUBSCRIBED, got %s", e.Type)
+	}
+
+	if !c.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}) {
+		t.Fatal("could not add hello")
+	}
+	<-events
+
+	time.Sleep(100 * time.Millisecond)
+	if expired := lease.Expire(); len(expired) != 1 {
+		t.Fatalf("expected hello to expire, got %v", expired)
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != protogen.EventType_REMOVED || e.Service.Name != "hello" {
+			t.Fatalf("expected hello to be removed, got %s %s", e.Type, e.Service.GetName())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the expiry was not streamed")
+	}
+
+	if n := testutil.ToFloat64(srv.catalog.prom.expirations.WithLabelValues("hello")); n != 1 {
+		t.Fatalf("expected 1 expiration, got %v", n)
+	}
+}
+
+// rejectingStore refuses every registration without being read-only
+type rejectingStore struct {
+	store.CatalogStore
+}
+
+func (rejectingStore) Add(name string, s store.ServiceInfo) bool {
+	return false
+}
+
+func TestAddToReadOnlyStore(t *testing.T) {
+	svc := &protogen.Service{Name: "hello", Address: "127.0.0.1:10000", SocketType: protogen.SocketType_TCP}
+	static := store.NewStaticCatalogStore(nil)
+
+	tests := []struct {
+		cs   store.CatalogStore
+		code codes.Code
+	}{
+		{static, codes.FailedPrecondition},
+		{store.NewLeaseCatalogStore(static, time.Minute), codes.FailedPrecondition},
+		{store.NewLayeredCatalogStore(store.LayeredOptions{}, store.Layer{Store: store.NewMemCatalogStore(), ReadOnly: true}, store.Layer{Store: static}), codes.FailedPrecondition},
+		{store.NewOverlayCatalogStore(store.NewMemCatalogStore(), static), codes.OK},
+		{rejectingStore{store.NewMemCatalogStore()}, codes.AlreadyExists},
+	}
+	for _, tt := range tests {
+		c := &CatalogServer{Impl: tt.cs}
+		if _, err := c.Add(context.Background(), svc); status.Code(err) != tt.code {
+			t.Fatalf("expected %s adding to %T, got %v", tt.code, tt.cs, err)
+		}
+	}
+}
+
+func TestDefaultOrigin(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	srv, err := NewServer(store.NewMemCatalogStore(), Options{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(context.Background())
+
+	c, err := Dial(srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if !c.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}) {
+		t.Fatal("could not add hello")
+	}
+	s, _ := c.Get("hello")
+	expected := net.JoinHostPort(hostname, strconv.Itoa(srv.Addr().(*net.TCPAddr).Port))
+	if s.Origin != expected {
+		t.Fatalf("expected origin %s, got %s", expected, s.Origin)
+	}
+}
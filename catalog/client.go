@@ -0,0 +1,419 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"github.com/cvhariharan/plugin/store"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors returned by Client, matched with errors.Is
+var (
+	ErrNotFound         = errors.New("service not found")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrAlreadyExists    = errors.New("service already exists")
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnsupported      = errors.New("not supported by the catalog store")
+	ErrReadOnly         = errors.New("catalog store is read-only")
+	ErrUnavailable      = errors.New("catalog unavailable")
+)
+
+// codeErrors maps the status codes returned by the catalog to the errors of Client
+var codeErrors = map[codes.Code]error{
+	codes.NotFound:           ErrNotFound,
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.AlreadyExists:      ErrAlreadyExists,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.PermissionDenied:   ErrPermissionDenied,
+	codes.Unimplemented:      ErrUnsupported,
+	codes.FailedPrecondition: ErrReadOnly,
+	codes.Unavailable:        ErrUnavailable,
+}
+
+// Defaults of ClientOptions
+const (
+	DEFAULT_CLIENT_TIMEOUT = 5 * time.Second
+	DEFAULT_CLIENT_RETRIES = 3
+	DEFAULT_CLIENT_BACKOFF = 100 * time.Millisecond
+	MAX_CLIENT_BACKOFF     = 5 * time.Second
+	DEFAULT_CACHE_TTL      = 30 * time.Second
+)
+
+// ClientOptions configures a catalog Client
+type ClientOptions struct {
+	// DialOptions are appended to the default dial options, so they can override them
+	DialOptions []grpc.DialOption
+
+	// Timeout bounds the calls made through the CatalogStore methods, which take no context.
+	// Defaults to DEFAULT_CLIENT_TIMEOUT.
+	Timeout time.Duration
+
+	// Retries is the number of times a call is retried when the catalog is unavailable,
+	// with an exponential backoff starting at Backoff. Defaults to DEFAULT_CLIENT_RETRIES, -1 disables retries.
+	Retries int
+	Backoff time.Duration
+
+	// Cache keeps the results of Get until the catalog reports a change to the service through Watch,
+	// for at most CacheTTL. Results are only cached while the watch is connected.
+	// CacheTTL bounds how stale changes the catalog does not stream can get, like services removed
+	// from its store directly or found through its peers. Defaults to DEFAULT_CACHE_TTL.
+	Cache    bool
+	CacheTTL time.Duration
+
+	// TracerProvider and MeterProvider are used to instrument the calls.
+	// Defaults to the global OpenTelemetry providers if nil.
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+}
+
+// Client is a typed client for the catalog service. It implements store.CatalogStore,
+// so a remote catalog can be used wherever a local store is, e.g. in plugin.Load.
+// Errors returned by the catalog match the Err variables of this package with errors.Is.
+type Client struct {
+	conn   *grpc.ClientConn
+	client protogen.CatalogClient
+	opt    ClientOptions
+
+	// cache holds the results of Get while watching is set.
+	// generation changes on every invalidation, so a lookup racing with a change is not cached.
+	mu         sync.Mutex
+	cache      map[string]cachedService
+	watching   bool
+	generation uint64
+	cancel     context.CancelFunc
+}
+
+type cachedService struct {
+	svc    store.ServiceInfo
+	expiry time.Time
+}
+
+// NewClient returns a client using conn, which is not closed by Close
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return newClient(conn, ClientOptions{})
+}
+
+// Dial connects to the catalog at address. Connections are insecure unless opts set transport credentials.
+func Dial(address string, opts ...grpc.DialOption) (*Client, error) {
+	return DialWithOptions(address, ClientOptions{DialOptions: opts})
+}
+
+// DialWithOptions connects to the catalog at address with the client configured by opt
+func DialWithOptions(address string, opt ClientOptions) (*Client, error) {
+	opts := append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			otelgrpc.WithTracerProvider(opt.TracerProvider),
+			otelgrpc.WithMeterProvider(opt.MeterProvider),
+		)),
+	}, opt.DialOptions...)
+
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(conn, opt)
+	c.conn = conn
+	return c, nil
+}
+
+func newClient(conn grpc.ClientConnInterface, opt ClientOptions) *Client {
+	if opt.Timeout == 0 {
+		opt.Timeout = DEFAULT_CLIENT_TIMEOUT
+	}
+	if opt.Retries == 0 {
+		opt.Retries = DEFAULT_CLIENT_RETRIES
+	}
+	if opt.Backoff == 0 {
+		opt.Backoff = DEFAULT_CLIENT_BACKOFF
+	}
+	if opt.CacheTTL == 0 {
+		opt.CacheTTL = DEFAULT_CACHE_TTL
+	}
+
+	c := &Client{
+		client: protogen.NewCatalogClient(conn),
+		opt:    opt,
+		cache:  make(map[string]cachedService),
+	}
+
+	if opt.Cache {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		go c.watch(ctx)
+	}
+
+	return c
+}
+
+// Close stops watching the catalog and closes the connection opened by Dial
+func (c *Client) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// AddContext registers a service in the catalog
+func (c *Client) AddContext(ctx context.Context, name string, s store.ServiceInfo) error {
+	c.invalidate(name)
+	return c.retry(ctx, func(ctx context.Context) error {
+		_, err := c.client.Add(ctx, fromServiceInfo(name, s))
+		return err
+	})
+}
+
+// GetContext looks a service up in the catalog, or in the cache if it is enabled
+func (c *Client) GetContext(ctx context.Context, name string) (store.ServiceInfo, error) {
+	s, ok, generation := c.cached(name)
+	if ok {
+		return s, nil
+	}
+
+	var svc *protogen.Service
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		svc, err = c.client.Get(ctx, &protogen.GetReq{Name: name})
+		return err
+	})
+	if err != nil {
+		return store.ServiceInfo{}, err
+	}
+
+	s = toServiceInfo(svc)
+	c.store(name, s, generation)
+	return s, nil
+}
+
+// ListContext returns every service in the catalog
+func (c *Client) ListContext(ctx context.Context) (map[string]store.ServiceInfo, error) {
+	var resp *protogen.ServiceList
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.client.List(ctx, &protogen.Empty{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string]store.ServiceInfo, len(resp.Services))
+	for _, svc := range resp.Services {
+		services[svc.Name] = toServiceInfo(svc)
+	}
+	return services, nil
+}
+
+// RemoveContext removes a service from the catalog
+func (c *Client) RemoveContext(ctx context.Context, name string) error {
+	c.invalidate(name)
+	return c.retry(ctx, func(ctx context.Context) error {
+		_, err := c.client.Remove(ctx, &protogen.GetReq{Name: name})
+		return err
+	})
+}
+
+// Add implements store.CatalogStore
+func (c *Client) Add(name string, s store.ServiceInfo) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opt.Timeout)
+	defer cancel()
+	return c.AddContext(ctx, name, s) == nil
+}
+
+// Get implements store.CatalogStore
+func (c *Client) Get(name string) (store.ServiceInfo, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opt.Timeout)
+	defer cancel()
+	s, err := c.GetContext(ctx, name)
+	return s, err == nil
+}
+
+// List implements store.Lister, it returns nil if the catalog cannot be listed
+func (c *Client) List() map[string]store.ServiceInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opt.Timeout)
+	defer cancel()
+	services, _ := c.ListContext(ctx)
+	return services
+}
+
+// Remove implements store.Remover
+func (c *Client) Remove(name string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opt.Timeout)
+	defer cancel()
+	return c.RemoveContext(ctx, name) == nil
+}
+
+// retry calls fn until it succeeds, fails with an error other than Unavailable, or runs out of retries
+func (c *Client) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := c.opt.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if status.Code(err) != codes.Unavailable || attempt >= c.opt.Retries {
+			return clientError(err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return clientError(err)
+		}
+		backoff = min(backoff*2, MAX_CLIENT_BACKOFF)
+	}
+}
+
+// watch keeps the cache in sync with the catalog, reconnecting with backoff when the stream breaks
+func (c *Client) watch(ctx context.Context) {
+	backoff := c.opt.Backoff
+	for ctx.Err() == nil {
+		if c.watchOnce(ctx) {
+			backoff = c.opt.Backoff
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff = min(backoff*2, MAX_CLIENT_BACKOFF)
+	}
+}
+
+// watchOnce applies the events of one watch stream to the cache and reports whether it was subscribed.
+// The cache is cleared when the stream ends, since changes may be missed until it reconnects.
+func (c *Client) watchOnce(ctx context.Context) bool {
+	defer c.setWatching(false)
+
+	subscribed := false
+	c.WatchContext(ctx, func(e *protogen.Event) {
+		switch e.Type {
+		case protogen.EventType_SUBSCRIBED:
+			subscribed = true
+			c.setWatching(true)
+		case protogen.EventType_ADDED, protogen.EventType_REMOVED:
+			c.invalidate(e.Service.GetName())
+		}
+	})
+	return subscribed
+}
+
+// WatchContext calls fn with the events of the catalog until ctx is done or the stream breaks, and returns the error that ended it.
+// The first event of every stream is SUBSCRIBED, so seeing it again means the client reconnected, possibly to a restarted catalog.
+func (c *Client) WatchContext(ctx context.Context, fn func(e *protogen.Event)) error {
+	stream, err := c.client.Watch(ctx, &protogen.Empty{})
+	if err != nil {
+		return clientError(err)
+	}
+
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			return clientError(err)
+		}
+		fn(e)
+	}
+}
+
+func (c *Client) setWatching(watching bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watching = watching
+	clear(c.cache)
+	c.generation++
+}
+
+// cached returns the unexpired cached service for name, along with the current generation
+func (c *Client) cached(name string) (store.ServiceInfo, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cs, ok := c.cache[name]
+	if ok && time.Now().After(cs.expiry) {
+		delete(c.cache, name)
+		ok = false
+	}
+	return cs.svc, ok, c.generation
+}
+
+// store caches the result of a lookup started at generation, unless the cache was invalidated since
+func (c *Client) store(name string, s store.ServiceInfo, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watching && c.generation == generation {
+		c.cache[name] = cachedService{svc: s, expiry: time.Now().Add(c.opt.CacheTTL)}
+	}
+}
+
+func (c *Client) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, name)
+	c.generation++
+}
+
+// StatusError is an error returned by the catalog. It unwraps to the Err variable matching its status code.
+type StatusError struct {
+	status *status.Status
+	err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.status.Message()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus returns the status returned by the catalog, so status.Code works on the error
+func (e *StatusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// clientError converts a status error to a StatusError, other errors are returned as is
+func clientError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	sentinel, ok := codeErrors[s.Code()]
+	if !ok {
+		return err
+	}
+	return &StatusError{status: s, err: sentinel}
+}
+
+// fromServiceInfo converts a service to its protobuf representation
+func fromServiceInfo(name string, s store.ServiceInfo) *protogen.Service {
+	socketType := protogen.SocketType_TCP
+	if s.Socket == store.UNIX {
+		socketType = protogen.SocketType_UNIX
+	}
+	return &protogen.Service{Name: name, Address: s.Address, SocketType: socketType, Origin: s.Origin}
+}
+
+// toServiceInfo converts a protobuf service to a service of the store
+func toServiceInfo(svc *protogen.Service) store.ServiceInfo {
+	socketType := store.TCP
+	if svc.SocketType == protogen.SocketType_UNIX {
+		socketType = store.UNIX
+	}
+	return store.ServiceInfo{Address: svc.Address, Socket: socketType, Origin: svc.Origin}
+}
@@ -0,0 +1,274 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc"
+)
+
+// startServer serves cs on address until the end of the test
+func startServer(t *testing.T, cs store.CatalogStore, address string) *Server {
+	t.Helper()
+
+	srv, err := NewServer(cs, Options{Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Stop(context.Background())
+	})
+	return srv
+}
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// countingStore counts the lookups reaching the store
+type countingStore struct {
+	store.CatalogStore
+	gets atomic.Int32
+}
+
+func (c *countingStore) Get(name string) (store.ServiceInfo, bool) {
+	c.gets.Add(1)
+	return c.CatalogStore.Get(name)
+}
+
+func (c *countingStore) Remove(name string) bool {
+	return c.CatalogStore.(store.Remover).Remove(name)
+}
+
+// countAttempts returns a dial option counting the calls made by a client, retries included
+func countAttempts(n *atomic.Int32) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		n.Add(1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+// waitFor polls cond until it holds
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func (c *Client) isWatching() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.watching
+}
+
+func (c *Client) isCached(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.cache[name]
+	return ok
+}
+
+func TestClientRetries(t *testing.T) {
+	var attempts atomic.Int32
+	c, err := DialWithOptions(freeAddress(t), ClientOptions{Retries: 2, Backoff: 10 * time.Millisecond, DialOptions: []grpc.DialOption{countAttempts(&attempts)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.GetContext(context.Background(), "hello"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the catalog to be unavailable, got %v", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	attempts.Store(0)
+	c.opt.Retries = -1
+	if _, err := c.GetContext(context.Background(), "hello"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the catalog to be unavailable, got %v", err)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("expected a single attempt with retries disabled, got %d", n)
+	}
+}
+
+func TestClientRetriesOnlyUnavailable(t *testing.T) {
+	srv := startServer(t, store.NewMemCatalogStore(), "127.0.0.1:0")
+
+	var attempts atomic.Int32
+	c, err := DialWithOptions(srv.Addr().String(), ClientOptions{DialOptions: []grpc.DialOption{countAttempts(&attempts)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.GetContext(context.Background(), "hello"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected hello to be missing, got %v", err)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("expected a missing service not to be retried, got %d attempts", n)
+	}
+}
+
+func TestClientRetriesUntilCatalogStarts(t *testing.T) {
+	address := freeAddress(t)
+
+	c, err := DialWithOptions(address, ClientOptions{Retries: 10, Backoff: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	cs := store.NewMemCatalogStore()
+	cs.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	type result struct {
+		s   store.ServiceInfo
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		s, err := c.GetContext(ctx, "hello")
+		done <- result{s, err}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	startServer(t, cs, address)
+
+	if r := <-done; r.err != nil || r.s.Address != "127.0.0.1:10000" {
+		t.Fatalf("expected hello once the catalog started, got %v, %v", r.s, r.err)
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	cs := &countingStore{CatalogStore: store.NewMemCatalogStore()}
+	cs.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP})
+	srv := startServer(t, cs, "127.0.0.1:0")
+
+	c, err := DialWithOptions(srv.Addr().String(), ClientOptions{Cache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, "the client did not start watching", c.isWatching)
+
+	other, err := Dial(srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	for i := 0; i < 3; i++ {
+		if s, ok := c.Get("hello"); !ok || s.Address != "127.0.0.1:10000" {
+			t.Fatalf("expected hello, got %v", s)
+		}
+	}
+	if n := cs.gets.Load(); n != 1 {
+		t.Fatalf("expected a single lookup in the store, got %d", n)
+	}
+
+	// A change made through another client is streamed and drops the cached service
+	if !other.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10001", Socket: store.TCP}) {
+		t.Fatal("could not update hello")
+	}
+	waitFor(t, "the update was not streamed", func() bool { return !c.isCached("hello") })
+	if s, ok := c.Get("hello"); !ok || s.Address != "127.0.0.1:10001" {
+		t.Fatalf("expected the updated hello, got %v", s)
+	}
+
+	if !other.Remove("hello") {
+		t.Fatal("could not remove hello")
+	}
+	waitFor(t, "the removal was not streamed", func() bool { return !c.isCached("hello") })
+	if _, ok := c.Get("hello"); ok {
+		t.Fatal("expected hello to be removed")
+	}
+}
+
+func TestClientCacheDroppedOnDisconnect(t *testing.T) {
+	cs := store.NewMemCatalogStore()
+	cs.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP})
+
+	srv, err := NewServer(cs, Options{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := DialWithOptions(srv.Addr().String(), ClientOptions{Cache: true, Retries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, "the client did not start watching", c.isWatching)
+
+	if _, ok := c.Get("hello"); !ok || !c.isCached("hello") {
+		t.Fatal("expected hello to be cached")
+	}
+
+	srv.Stop(context.Background())
+	waitFor(t, "the client kept watching a stopped catalog", func() bool { return !c.isWatching() })
+	if c.isCached("hello") {
+		t.Fatal("expected the cache to be dropped with the watch")
+	}
+	if _, err := c.GetContext(context.Background(), "hello"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected lookups to fail while the catalog is down, got %v", err)
+	}
+}
+
+func TestClientCacheTTL(t *testing.T) {
+	cs := store.NewMemCatalogStore()
+	cs.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP})
+	srv := startServer(t, cs, "127.0.0.1:0")
+
+	ttl := 200 * time.Millisecond
+	c, err := DialWithOptions(srv.Addr().String(), ClientOptions{Cache: true, CacheTTL: ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, "the client did not start watching", c.isWatching)
+
+	if _, ok := c.Get("hello"); !ok {
+		t.Fatal("expected hello")
+	}
+
+	// Removing hello from the store directly is not streamed, the client only notices once its cache expires
+	cs.(store.Remover).Remove("hello")
+	if _, ok := c.Get("hello"); !ok {
+		t.Fatal("expected hello to be served from the cache")
+	}
+
+	time.Sleep(ttl)
+	if _, ok := c.Get("hello"); ok {
+		t.Fatal("expected hello to be looked up again once the cache expired")
+	}
+}
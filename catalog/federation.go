@@ -0,0 +1,38 @@
+package catalog
+
+import (
+	"context"
+
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc/metadata"
+)
+
+// FORWARDED_KEY marks lookups forwarded by a peer catalog, so they are not forwarded again
+const FORWARDED_KEY = "x-catalog-forwarded"
+
+// forward looks name up in the peers of the catalog, unless the lookup was itself forwarded.
+// Peers that fail are skipped, the service is only reported missing if no peer has it.
+func (c *CatalogServer) forward(ctx context.Context, name string) (store.ServiceInfo, bool) {
+	if len(c.Peers) == 0 || forwarded(ctx) {
+		return store.ServiceInfo{}, false
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, FORWARDED_KEY, c.Origin)
+	for _, peer := range c.Peers {
+		svcInfo, err := peer.GetContext(ctx, name)
+		if err == nil {
+			return svcInfo, true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return store.ServiceInfo{}, false
+}
+
+// forwarded reports whether the incoming call was forwarded by a peer catalog
+func forwarded(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return len(md.Get(FORWARDED_KEY)) > 0
+}
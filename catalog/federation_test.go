@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// lookups records the FORWARDED_KEY values of the lookups received by a catalog, empty for direct lookups
+type lookups struct {
+	mu        sync.Mutex
+	forwarded []string
+}
+
+func (l *lookups) interceptor() grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == "/catalog.Catalog/Get" {
+			md, _ := metadata.FromIncomingContext(ctx)
+			l.mu.Lock()
+			l.forwarded = append(l.forwarded, append(md.Get(FORWARDED_KEY), "")[0])
+			l.mu.Unlock()
+		}
+		return handler(ctx, req)
+	})
+}
+
+func (l *lookups) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.forwarded...)
+}
+
+// startPeer serves cs on lis with the given peers until the end of the test, recording its lookups in l
+func startPeer(t *testing.T, cs store.CatalogStore, lis net.Listener, l *lookups, peers ...string) *Server {
+	t.Helper()
+
+	srv, err := NewServer(cs, Options{Listener: lis, Peers: peers, GRPCOptions: []grpc.ServerOption{l.interceptor()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Stop(context.Background())
+	})
+	return srv
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lis
+}
+
+func TestForwardToPeer(t *testing.T) {
+	var peerLookups, lookupsA lookups
+	peer := startPeer(t, store.NewMemCatalogStore(), listen(t), &peerLookups)
+
+	// The first peer is unreachable and skipped
+	a := startPeer(t, store.NewMemCatalogStore(), listen(t), &lookupsA, freeAddress(t), peer.Addr().String())
+
+	reg, err := Dial(peer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	if !reg.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}) {
+		t.Fatal("could not add hello")
+	}
+
+	c, err := Dial(a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s, err := c.GetContext(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("expected hello to be found through the peer, got %v", err)
+	}
+	if s.Address != "127.0.0.1:10000" || s.Origin != peer.catalog.Origin {
+		t.Fatalf("expected hello registered with the peer, got %v", s)
+	}
+
+	// The lookup is forwarded with the default origin of the catalog, hostname:port
+	expected := a.catalog.Origin
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		expected = net.JoinHostPort(hostname, strconv.Itoa(a.Addr().(*net.TCPAddr).Port))
+	}
+	if forwarded := peerLookups.get(); len(forwarded) != 1 || forwarded[0] != expected {
+		t.Fatalf("expected a single lookup forwarded by %s, got %q", expected, forwarded)
+	}
+}
+
+func TestForwardLoop(t *testing.T) {
+	lisA, lisB := listen(t), listen(t)
+	var lookupsA, lookupsB lookups
+	a := startPeer(t, store.NewMemCatalogStore(), lisA, &lookupsA, lisB.Addr().String())
+	startPeer(t, store.NewMemCatalogStore(), lisB, &lookupsB, lisA.Addr().String())
+
+	c, err := Dial(a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Catalogs peering with each other do not forward a missing service back and forth
+	if _, err := c.GetContext(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected missing not to be found, got %v", err)
+	}
+	if n := len(lookupsA.get()); n != 1 {
+		t.Fatalf("expected a single lookup in the first catalog, got %d", n)
+	}
+	if forwarded := lookupsB.get(); len(forwarded) != 1 || forwarded[0] != a.catalog.Origin {
+		t.Fatalf("expected a single forwarded lookup in the second catalog, got %q", forwarded)
+	}
+}
@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/cvhariharan/plugin/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promMetrics are the catalog metrics exposed on the /metrics endpoint
+type promMetrics struct {
+	registry      *prometheus.Registry
+	registrations *prometheus.CounterVec
+	lookups       *prometheus.CounterVec
+	misses        *prometheus.CounterVec
+	expirations   *prometheus.CounterVec
+}
+
+// newPromMetrics registers the catalog metrics on a new registry.
+// If cs can report its size, it is exposed as the catalog_services gauge.
+func newPromMetrics(cs store.CatalogStore) *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catalog_registrations_total",
+			Help: "Number of services added to the catalog.",
+		}, []string{"name", "result"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catalog_lookups_total",
+			Help: "Number of catalog lookups.",
+		}, []string{"name"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catalog_lookup_misses_total",
+			Help: "Number of catalog lookups for services that were not found.",
+		}, []string{"name"}),
+		expirations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catalog_lease_expirations_total",
+			Help: "Number of services removed because their lease expired.",
+		}, []string{"name"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.registrations,
+		m.lookups,
+		m.misses,
+		m.expirations,
+	)
+
+	if sized, ok := cs.(interface{ Len() int }); ok {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "catalog_services",
+			Help: "Number of services in the catalog.",
+		}, func() float64 {
+			return float64(sized.Len())
+		}))
+	}
+
+	return m
+}
+
+func (m *promMetrics) recordRegistration(name string, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	m.registrations.WithLabelValues(name, result).Inc()
+}
+
+func (m *promMetrics) recordLookup(name string, found bool) {
+	m.lookups.WithLabelValues(name).Inc()
+	if !found {
+		m.misses.WithLabelValues(name).Inc()
+	}
+}
+
+func (m *promMetrics) recordExpiration(name string) {
+	m.expirations.WithLabelValues(name).Inc()
+}
+
+// httpHandler serves /metrics, /healthz and /readyz.
+// The catalog is ready once ready is set, which happens when the gRPC server starts serving.
+func (m *promMetrics) httpHandler(ready *atomic.Bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
@@ -20,6 +20,9 @@ const _ = grpc.SupportPackageIsVersion7
 type CatalogClient interface {
 	Add(ctx context.Context, in *Service, opts ...grpc.CallOption) (*Empty, error)
 	Get(ctx context.Context, in *GetReq, opts ...grpc.CallOption) (*Service, error)
+	List(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ServiceList, error)
+	Remove(ctx context.Context, in *GetReq, opts ...grpc.CallOption) (*Empty, error)
+	Watch(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Catalog_WatchClient, error)
 }
 
 type catalogClient struct {
@@ -48,12 +51,65 @@ func (c *catalogClient) Get(ctx context.Context, in *GetReq, opts ...grpc.CallOp
 	return out, nil
 }
 
+func (c *catalogClient) List(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ServiceList, error) {
+	out := new(ServiceList)
+	err := c.cc.Invoke(ctx, "/catalog.Catalog/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catalogClient) Remove(ctx context.Context, in *GetReq, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/catalog.Catalog/Remove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catalogClient) Watch(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Catalog_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Catalog_ServiceDesc.Streams[0], "/catalog.Catalog/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &catalogWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Catalog_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type catalogWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *catalogWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // CatalogServer is the server API for Catalog service.
 // All implementations must embed UnimplementedCatalogServer
 // for forward compatibility
 type CatalogServer interface {
 	Add(context.Context, *Service) (*Empty, error)
 	Get(context.Context, *GetReq) (*Service, error)
+	List(context.Context, *Empty) (*ServiceList, error)
+	Remove(context.Context, *GetReq) (*Empty, error)
+	Watch(*Empty, Catalog_WatchServer) error
 	mustEmbedUnimplementedCatalogServer()
 }
 
@@ -67,6 +123,15 @@ func (UnimplementedCatalogServer) Add(context.Context, *Service) (*Empty, error)
 func (UnimplementedCatalogServer) Get(context.Context, *GetReq) (*Service, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
+func (UnimplementedCatalogServer) List(context.Context, *Empty) (*ServiceList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedCatalogServer) Remove(context.Context, *GetReq) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedCatalogServer) Watch(*Empty, Catalog_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedCatalogServer) mustEmbedUnimplementedCatalogServer() {}
 
 // UnsafeCatalogServer may be embedded to opt out of forward compatibility for this service.
@@ -116,6 +181,63 @@ func _Catalog_Get_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Catalog_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatalogServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/catalog.Catalog/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatalogServer).List(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Catalog_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatalogServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/catalog.Catalog/Remove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatalogServer).Remove(ctx, req.(*GetReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Catalog_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CatalogServer).Watch(m, &catalogWatchServer{stream})
+}
+
+type Catalog_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type catalogWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *catalogWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Catalog_ServiceDesc is the grpc.ServiceDesc for Catalog service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -131,7 +253,21 @@ var Catalog_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Get",
 			Handler:    _Catalog_Get_Handler,
 		},
+		{
+			MethodName: "List",
+			Handler:    _Catalog_List_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _Catalog_Remove_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Catalog_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "catalog/protos/catalog.proto",
 }
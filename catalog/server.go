@@ -0,0 +1,267 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"github.com/cvhariharan/plugin/store"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+const (
+	NETWORK_TCP  = "tcp"
+	NETWORK_UNIX = "unix"
+)
+
+// Options configures the catalog server
+type Options struct {
+	// Address is the host:port the gRPC server listens on, or the path of the socket for unix sockets.
+	// A port of 0 picks a free port, which is reported by Server.Addr.
+	Address string
+
+	// Network is NETWORK_TCP, the default, or NETWORK_UNIX
+	Network string
+
+	// Listener, if set, is used instead of listening on Address
+	Listener net.Listener
+
+	// GRPCOptions can be used to set interceptors, credentials or message size limits on the server
+	GRPCOptions []grpc.ServerOption
+
+	// HTTPAddress, if set, starts an HTTP server on that address serving
+	// Prometheus metrics on /metrics and the /healthz and /readyz probes
+	HTTPAddress string
+
+	// Auth, if set, requires registrations to be authenticated and allowed by its ACL
+	Auth *AuthOptions
+
+	// Origin identifies this catalog in the services registered with it and in the lookups it forwards to peers.
+	// Defaults to the hostname and the port it listens on, or the socket path for unix sockets,
+	// since listen addresses like :10000 are the same on every host.
+	Origin string
+
+	// Peers are the addresses of catalogs that are asked, in order, for services missing from this one.
+	// PeerDialOptions are appended to the dial options of the peers, e.g. to set credentials.
+	Peers           []string
+	PeerDialOptions []grpc.DialOption
+
+	// TracerProvider and MeterProvider are used to instrument the server, its metrics and the calls to peers.
+	// Defaults to the global OpenTelemetry providers if nil.
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+}
+
+// Server is a catalog server that can be started and stopped
+type Server struct {
+	opt     Options
+	grpc    *grpc.Server
+	catalog *CatalogServer
+
+	lis   net.Listener
+	http  *http.Server
+	ready atomic.Bool
+
+	done chan struct{}
+	err  error
+}
+
+// NewServer creates a catalog server for cs configured by opt, Start has to be called to serve
+func NewServer(cs store.CatalogStore, opt Options) (*Server, error) {
+	if opt.Network == "" {
+		opt.Network = NETWORK_TCP
+	}
+
+	if opt.Listener == nil {
+		switch opt.Network {
+		case NETWORK_TCP:
+			if _, _, err := net.SplitHostPort(opt.Address); err != nil {
+				return nil, fmt.Errorf("invalid address format: %w", err)
+			}
+		case NETWORK_UNIX:
+			if opt.Address == "" {
+				return nil, fmt.Errorf("unix socket path is required")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported network %s", opt.Network)
+		}
+	}
+
+	if opt.Auth != nil {
+		if err := opt.Auth.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid auth options: %w", err)
+		}
+	}
+
+	serverOpts := append([]grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(opt.TracerProvider),
+		otelgrpc.WithMeterProvider(opt.MeterProvider),
+	))}, opt.GRPCOptions...)
+	srv := &Server{
+		opt:  opt,
+		grpc: grpc.NewServer(serverOpts...),
+		catalog: &CatalogServer{
+			Impl:          cs,
+			Auth:          opt.Auth,
+			Origin:        opt.Origin,
+			MeterProvider: opt.MeterProvider,
+		},
+		done: make(chan struct{}),
+	}
+
+	// Created up front, since expired leases are recorded before the server starts
+	if opt.HTTPAddress != "" {
+		srv.catalog.prom = newPromMetrics(cs)
+	}
+
+	if expirer, ok := cs.(store.Expirer); ok {
+		expirer.OnExpire(srv.catalog.expired)
+	}
+
+	for _, address := range opt.Peers {
+		peer, err := DialWithOptions(address, ClientOptions{
+			DialOptions:    opt.PeerDialOptions,
+			Retries:        -1,
+			Cache:          true,
+			TracerProvider: opt.TracerProvider,
+			MeterProvider:  opt.MeterProvider,
+		})
+		if err != nil {
+			srv.closePeers()
+			return nil, fmt.Errorf("could not connect to peer catalog %s: %w", address, err)
+		}
+		srv.catalog.Peers = append(srv.catalog.Peers, peer)
+	}
+
+	protogen.RegisterCatalogServer(srv.grpc, srv.catalog)
+	reflection.Register(srv.grpc)
+
+	return srv, nil
+}
+
+// Start listens on the configured address and serves in the background.
+// It returns once the server is listening, so Addr can be called.
+func (s *Server) Start() error {
+	lis := s.opt.Listener
+	if lis == nil {
+		var err error
+		lis, err = net.Listen(s.opt.Network, s.opt.Address)
+		if err != nil {
+			return fmt.Errorf("could not start catalog server, could not listen on address: %w", err)
+		}
+	}
+	s.lis = lis
+
+	if s.catalog.Origin == "" {
+		s.catalog.Origin = defaultOrigin(lis.Addr())
+	}
+
+	if s.opt.HTTPAddress != "" {
+		httpLis, err := net.Listen("tcp", s.opt.HTTPAddress)
+		if err != nil {
+			lis.Close()
+			return fmt.Errorf("could not start catalog http server, could not listen on address: %w", err)
+		}
+
+		s.http = &http.Server{Handler: s.catalog.prom.httpHandler(&s.ready)}
+		go s.http.Serve(httpLis)
+	}
+
+	s.ready.Store(true)
+	go func() {
+		s.err = s.grpc.Serve(lis)
+		if s.http != nil {
+			s.http.Close()
+		}
+		s.closePeers()
+		close(s.done)
+	}()
+
+	return nil
+}
+
+// defaultOrigin returns hostname:port for TCP listeners and hostname:path for unix sockets,
+// falling back to the address itself if the hostname is unknown
+func defaultOrigin(addr net.Addr) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return addr.String()
+	}
+
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return net.JoinHostPort(hostname, strconv.Itoa(tcp.Port))
+	}
+	return hostname + ":" + addr.String()
+}
+
+// Addr returns the address the server listens on, or nil if it was not started
+func (s *Server) Addr() net.Addr {
+	if s.lis == nil {
+		return nil
+	}
+	return s.lis.Addr()
+}
+
+// Wait blocks until a started server stops and returns the error that stopped it, nil if it was stopped
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// GracefulStop stops accepting connections and waits for in flight calls to finish
+func (s *Server) GracefulStop() {
+	s.ready.Store(false)
+	s.catalog.events.close()
+	s.grpc.GracefulStop()
+	s.wait()
+}
+
+// Stop stops the server gracefully, closing the remaining connections when ctx is done.
+// It returns the error of ctx if calls had to be interrupted, without waiting for their handlers,
+// which can keep running if they do not return once cancelled. Wait returns once they have.
+func (s *Server) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-stopped:
+		return nil
+	default:
+		// grpc waits for the handlers even when stopping immediately, a handler stuck in the store would block Stop
+		go s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// wait waits for the serving goroutine to exit if the server was started, which closes the peers otherwise
+func (s *Server) wait() {
+	if s.lis != nil {
+		<-s.done
+		return
+	}
+	s.closePeers()
+}
+
+func (s *Server) closePeers() {
+	for _, peer := range s.catalog.Peers {
+		peer.Close()
+	}
+}
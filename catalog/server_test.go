@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+)
+
+func TestServerAddr(t *testing.T) {
+	srv, err := NewServer(store.NewMemCatalogStore(), Options{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Addr() != nil {
+		t.Fatalf("expected no address before Start, got %s", srv.Addr())
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(context.Background())
+
+	if port := srv.Addr().(*net.TCPAddr).Port; port == 0 {
+		t.Fatal("expected port 0 to be replaced by the port picked by the system")
+	}
+
+	c, err := Dial(srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.Add("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}) {
+		t.Fatal("could not add hello")
+	}
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.sock")
+	srv, err := NewServer(store.NewMemCatalogStore(), Options{Address: path, Network: NETWORK_UNIX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(context.Background())
+
+	if srv.Addr().String() != path {
+		t.Fatalf("expected the server to listen on %s, got %s", path, srv.Addr())
+	}
+
+	c, err := Dial("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.Add("hello", store.ServiceInfo{Address: "/run/hello.sock", Socket: store.UNIX}) {
+		t.Fatal("could not add hello")
+	}
+	if s, ok := c.Get("hello"); !ok || s.Address != "/run/hello.sock" {
+		t.Fatalf("expected hello, got %v", s)
+	}
+
+	if _, err := NewServer(store.NewMemCatalogStore(), Options{Network: NETWORK_UNIX}); err == nil {
+		t.Fatal("expected a unix socket without a path to be rejected")
+	}
+}
+
+// blockingStore blocks lookups until unblock is closed
+type blockingStore struct {
+	store.CatalogStore
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingStore) Get(name string) (store.ServiceInfo, bool) {
+	close(b.started)
+	<-b.unblock
+	return b.CatalogStore.Get(name)
+}
+
+func TestServerStopForcesAfterContext(t *testing.T) {
+	cs := &blockingStore{CatalogStore: store.NewMemCatalogStore(), started: make(chan struct{}), unblock: make(chan struct{})}
+
+	srv, err := NewServer(cs, Options{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := DialWithOptions(srv.Addr().String(), ClientOptions{Retries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go c.Get("hello")
+	<-cs.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- srv.Stop(ctx)
+	}()
+
+	select {
+	case err := <-stopped:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected Stop to report that calls were interrupted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return once its context expired")
+	}
+
+	if _, err := net.DialTimeout("tcp", srv.Addr().String(), time.Second); err == nil {
+		t.Fatal("expected the server to stop accepting connections")
+	}
+
+	cs.unblock <- struct{}{}
+	if err := srv.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
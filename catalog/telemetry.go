@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cvhariharan/plugin/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/cvhariharan/plugin/catalog"
+
+// UNKNOWN_SERVICE replaces the name in the metrics of failed registrations and of lookup misses
+// past MAX_MISSED_NAMES, so the names sent by clients cannot grow the number of metric series without bound
+const UNKNOWN_SERVICE = "unknown"
+
+// MAX_MISSED_NAMES is the number of distinct names recorded for lookups of services that were not found
+const MAX_MISSED_NAMES = 100
+
+// catalogMetrics holds the metric instruments of a CatalogServer
+type catalogMetrics struct {
+	registrations metric.Int64Counter
+	lookups       metric.Int64Counter
+	expirations   metric.Int64Counter
+}
+
+// newCatalogMetrics creates the catalog instruments using mp, or the global meter provider if nil.
+// If cs can report its size, it is exposed as the catalog.services gauge.
+func newCatalogMetrics(cs store.CatalogStore, mp metric.MeterProvider) *catalogMetrics {
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	m := &catalogMetrics{}
+	m.registrations, _ = meter.Int64Counter("catalog.registrations",
+		metric.WithDescription("Number of services added to the catalog"),
+	)
+	m.lookups, _ = meter.Int64Counter("catalog.lookups",
+		metric.WithDescription("Number of catalog lookups, by whether the service was found"),
+	)
+	m.expirations, _ = meter.Int64Counter("catalog.lease.expirations",
+		metric.WithDescription("Number of services removed because their lease expired"),
+	)
+
+	if sized, ok := cs.(interface{ Len() int }); ok {
+		meter.Int64ObservableGauge("catalog.services",
+			metric.WithDescription("Number of services in the catalog"),
+			metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+				o.Observe(int64(sized.Len()))
+				return nil
+			}),
+		)
+	}
+
+	return m
+}
+
+func (m *catalogMetrics) recordRegistration(ctx context.Context, name string, ok bool) {
+	m.registrations.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("plugin.name", name),
+		attribute.Bool("success", ok),
+	))
+}
+
+func (m *catalogMetrics) recordLookup(ctx context.Context, name string, found bool) {
+	m.lookups.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("plugin.name", name),
+		attribute.Bool("found", found),
+	))
+}
+
+func (m *catalogMetrics) recordExpiration(ctx context.Context, name string) {
+	m.expirations.Add(ctx, 1, metric.WithAttributes(attribute.String("plugin.name", name)))
+}
+
+// metricName returns the name recorded in the metrics of a registration, which is only
+// the name of the service if it succeeded, since the name of a failed one may be invalid
+func metricName(name string, ok bool) string {
+	if !ok {
+		return UNKNOWN_SERVICE
+	}
+	return name
+}
+
+// missedNames tracks the names recorded for lookup misses, up to MAX_MISSED_NAMES
+type missedNames struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// label returns the name recorded for a miss of name, UNKNOWN_SERVICE once MAX_MISSED_NAMES other names were recorded
+func (m *missedNames) label(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.names[name] {
+		return name
+	}
+	if len(m.names) >= MAX_MISSED_NAMES {
+		return UNKNOWN_SERVICE
+	}
+	if m.names == nil {
+		m.names = make(map[string]bool)
+	}
+	m.names[name] = true
+	return name
+}
@@ -0,0 +1,62 @@
+package catalog
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+)
+
+// MAX_NAME_LENGTH is the maximum length of a service name
+const MAX_NAME_LENGTH = 253
+
+// namePattern is the format of service names, letters, digits, dots, dashes and underscores
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateName checks that name is a valid service name
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("service name is required")
+	}
+	if len(name) > MAX_NAME_LENGTH {
+		return fmt.Errorf("service name is longer than %d characters", MAX_NAME_LENGTH)
+	}
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("service name %q can only contain letters, digits, '.', '-' and '_'", name)
+	}
+	return nil
+}
+
+// validateService checks the name, socket type and address of a service
+func validateService(svc *protogen.Service) error {
+	if err := validateName(svc.Name); err != nil {
+		return err
+	}
+
+	switch svc.SocketType {
+	case protogen.SocketType_TCP:
+		host, port, err := net.SplitHostPort(svc.Address)
+		if err != nil {
+			return fmt.Errorf("invalid tcp address %q: %v", svc.Address, err)
+		}
+		if host == "" {
+			return fmt.Errorf("tcp address %q has no host", svc.Address)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("tcp address %q has an invalid port", svc.Address)
+		}
+
+	case protogen.SocketType_UNIX:
+		if !filepath.IsAbs(svc.Address) {
+			return fmt.Errorf("unix socket address %q is not an absolute path", svc.Address)
+		}
+
+	default:
+		return fmt.Errorf("invalid socket type %d", svc.SocketType)
+	}
+
+	return nil
+}
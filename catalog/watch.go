@@ -0,0 +1,122 @@
+package catalog
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WATCH_BUFFER_SIZE is the number of events buffered for a watcher before it is disconnected
+const WATCH_BUFFER_SIZE = 256
+
+// eventBroker fans out the changes made through the catalog server to its watchers.
+// The zero value is ready to use.
+type eventBroker struct {
+	mu       sync.Mutex
+	watchers map[chan *protogen.Event]struct{}
+	closed   bool
+}
+
+// subscribe returns a channel receiving every event published from now on.
+// The channel is closed if the watcher falls behind, so it can resynchronize.
+func (b *eventBroker) subscribe() chan *protogen.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.watchers == nil {
+		b.watchers = make(map[chan *protogen.Event]struct{})
+	}
+
+	ch := make(chan *protogen.Event, WATCH_BUFFER_SIZE)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.watchers[ch] = struct{}{}
+	return ch
+}
+
+// close disconnects every watcher, so a graceful stop does not wait for their streams
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.watchers {
+		delete(b.watchers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBroker) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *eventBroker) unsubscribe(ch chan *protogen.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.watchers[ch]; ok {
+		delete(b.watchers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBroker) publish(e *protogen.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.watchers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Watch streams the services added to and removed from the catalog through this server.
+// The first event is SUBSCRIBED, sent once the watcher receives every later change.
+// Services removed by a store.Expirer, like expired leases, are streamed as REMOVED,
+// other changes made to the store directly are not.
+func (c *CatalogServer) Watch(req *protogen.Empty, stream protogen.Catalog_WatchServer) error {
+	ch := c.events.subscribe()
+	defer c.events.unsubscribe(ch)
+
+	if err := stream.Send(&protogen.Event{Type: protogen.EventType_SUBSCRIBED}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok && c.events.isClosed() {
+				return status.Errorf(codes.Unavailable, "catalog is shutting down")
+			}
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "watcher fell behind")
+			}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// expired tells the watchers about a service removed by the store on its own
+func (c *CatalogServer) expired(name string) {
+	c.events.publish(&protogen.Event{Type: protogen.EventType_REMOVED, Service: &protogen.Service{Name: name}})
+
+	c.getMetrics().recordExpiration(context.Background(), name)
+	if c.prom != nil {
+		c.prom.recordExpiration(name)
+	}
+}
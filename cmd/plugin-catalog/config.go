@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file of the catalog daemon.
+// Environment variables in the file, like ${CATALOG_TOKEN}, are expanded.
+type Config struct {
+	// Address is the host:port the catalog listens on
+	Address string `yaml:"address"`
+
+	// HTTPAddress, if set, serves Prometheus metrics on /metrics and the /healthz and /readyz probes
+	HTTPAddress string `yaml:"http_address"`
+
+	TLS     TLSConfig     `yaml:"tls"`
+	Storage StorageConfig `yaml:"storage"`
+
+	// LeaseTTL, if set, removes services that do not register again within it
+	LeaseTTL time.Duration `yaml:"lease_ttl"`
+
+	// Origin identifies the catalog in the services registered with it, defaults to the hostname and listen port
+	Origin string `yaml:"origin"`
+
+	// Peers are catalogs asked, in order, for services missing from this one
+	Peers []string `yaml:"peers"`
+
+	Log  LogConfig   `yaml:"log"`
+	Auth *AuthConfig `yaml:"auth"`
+}
+
+// TLSConfig enables TLS when CertFile and KeyFile are set, and requires client certificates signed by ClientCAFile if set.
+// Peers are dialed with TLS if PeerCAFile is set, and verified against it.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	PeerCAFile   string `yaml:"peer_ca_file"`
+}
+
+// StorageConfig selects the store backing the catalog
+type StorageConfig struct {
+	// Backend is the type of store, only "memory" is supported
+	Backend string `yaml:"backend"`
+
+	// StaticFile, if set, is a YAML or JSON file of services known up front, see store.StaticFile.
+	// It is checked for changes every ReloadInterval and reloaded on SIGHUP.
+	StaticFile     string        `yaml:"static_file"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	// StaticEnv adds the services set in PLUGIN_CATALOG_<NAME> environment variables.
+	// Registered services override them, and they override the static file.
+	StaticEnv bool `yaml:"static_env"`
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+
+	// Format is text or json
+	Format string `yaml:"format"`
+}
+
+// AuthConfig is the YAML form of catalog.AuthOptions
+type AuthConfig struct {
+	Tokens  map[string]string   `yaml:"tokens"`
+	Keys    map[string]string   `yaml:"keys"`
+	ACL     map[string][]string `yaml:"acl"`
+	MaxSkew time.Duration       `yaml:"max_skew"`
+}
+
+// defaultConfig returns the configuration used for the settings missing from the file
+func defaultConfig() Config {
+	return Config{
+		Address: ":50051",
+		Storage: StorageConfig{Backend: "memory", ReloadInterval: 5 * time.Second},
+		Log:     LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// loadConfig reads the configuration file at path on top of the defaults, an empty path only returns the defaults
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("could not read config: %v", err)
+	}
+
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(b))), &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse config %s: %v", path, err)
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	if c.Storage.Backend != "memory" {
+		return fmt.Errorf("unsupported storage backend %q", c.Storage.Backend)
+	}
+
+	if c.Storage.ReloadInterval < 0 {
+		return fmt.Errorf("storage reload_interval cannot be negative")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls requires both cert_file and key_file")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		return fmt.Errorf("tls client_ca_file requires cert_file and key_file")
+	}
+
+	for _, peer := range c.Peers {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return fmt.Errorf("invalid peer address %q: %v", peer, err)
+		}
+	}
+
+	if c.LeaseTTL < 0 {
+		return fmt.Errorf("lease_ttl cannot be negative")
+	}
+
+	// Tokens and keys usually come from environment variables, which expand to nothing when they are not set
+	if c.Auth != nil {
+		for token, identity := range c.Auth.Tokens {
+			if token == "" {
+				return fmt.Errorf("auth token of %s is empty, check that its environment variable is set", identity)
+			}
+		}
+		for identity, key := range c.Auth.Keys {
+			if key == "" {
+				return fmt.Errorf("auth key of %s is empty, check that its environment variable is set", identity)
+			}
+		}
+	}
+
+	if _, err := parseLevel(c.Log.Level); err != nil {
+		return err
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("unsupported log format %q", c.Log.Format)
+	}
+
+	return nil
+}
+
+// authOptions converts the auth section to catalog.AuthOptions, nil if authentication is disabled
+func (c Config) authOptions() *catalog.AuthOptions {
+	if c.Auth == nil {
+		return nil
+	}
+
+	keys := make(map[string][]byte, len(c.Auth.Keys))
+	for identity, key := range c.Auth.Keys {
+		keys[identity] = []byte(key)
+	}
+
+	return &catalog.AuthOptions{
+		Tokens:  c.Auth.Tokens,
+		Keys:    keys,
+		ACL:     c.Auth.ACL,
+		MaxSkew: c.Auth.MaxSkew,
+	}
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return level, fmt.Errorf("unsupported log level %q", s)
+	}
+	return level, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "catalog.yaml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigRejectsEmptyCredentials(t *testing.T) {
+	t.Setenv("CATALOG_TOKEN", "")
+	t.Setenv("BILLING_KEY", "")
+
+	for _, config := range []string{
+		"auth:\n  tokens:\n    \"${CATALOG_TOKEN}\": ops\n",
+		"auth:\n  keys:\n    billing-team: ${BILLING_KEY}\n",
+	} {
+		if _, err := loadConfig(writeConfig(t, config)); err == nil || !strings.Contains(err.Error(), "empty") {
+			t.Fatalf("expected an empty credential to be rejected in %q, got %v", config, err)
+		}
+	}
+
+	t.Setenv("CATALOG_TOKEN", "secret")
+	t.Setenv("BILLING_KEY", "key")
+	cfg, err := loadConfig(writeConfig(t, "auth:\n  tokens:\n    \"${CATALOG_TOKEN}\": ops\n  keys:\n    billing-team: ${BILLING_KEY}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.Tokens["secret"] != "ops" || cfg.Auth.Keys["billing-team"] != "key" {
+		t.Fatalf("unexpected auth config %+v", cfg.Auth)
+	}
+}
@@ -0,0 +1,351 @@
+// Command plugin-catalog runs the catalog discovery service as a standalone daemon.
+//
+//	plugin-catalog [-config catalog.yaml] [-address :50051] [-http-address :9090] [-log-level info] [-log-format text]
+//
+// Flags override the configuration file. SIGHUP reloads the configuration, applying the log level,
+// credentials, ACLs, lease TTL, TLS certificates and static services, and SIGTERM or SIGINT stop the catalog gracefully.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog"
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	configPath  = flag.String("config", "", "path of the YAML configuration file")
+	address     = flag.String("address", "", "address the catalog listens on, overrides the config")
+	httpAddress = flag.String("http-address", "", "address of the metrics and health endpoints, overrides the config")
+	logLevel    = flag.String("log-level", "", "debug, info, warn or error, overrides the config")
+	logFormat   = flag.String("log-format", "", "text or json, overrides the config")
+)
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		slog.Error("catalog stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// daemon holds the state of the catalog that can change when the configuration is reloaded
+type daemon struct {
+	cfg    Config
+	level  slog.LevelVar
+	log    *slog.Logger
+	auth   *catalog.AuthOptions
+	lease  *store.LeaseCatalogStore
+	static *store.StaticCatalogStore
+	cert   atomic.Pointer[tls.Certificate]
+}
+
+func run() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	d := &daemon{cfg: cfg, auth: cfg.authOptions()}
+	d.level.Set(mustLevel(cfg.Log.Level))
+	d.log = newLogger(cfg.Log.Format, &d.level)
+	slog.SetDefault(d.log)
+
+	cs, err := d.newStore()
+	if err != nil {
+		return err
+	}
+	if d.static != nil {
+		defer d.static.Close()
+	}
+
+	if cfg.LeaseTTL > 0 {
+		d.lease = store.NewLeaseCatalogStore(cs, cfg.LeaseTTL)
+		cs = d.lease
+	}
+
+	grpcOpts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(d.logUnaryInterceptor)}
+	if cfg.TLS.CertFile != "" {
+		tlsConfig, err := d.tlsConfig()
+		if err != nil {
+			return err
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	var peerOpts []grpc.DialOption
+	if cfg.TLS.PeerCAFile != "" {
+		creds, err := credentials.NewClientTLSFromFile(cfg.TLS.PeerCAFile, "")
+		if err != nil {
+			return fmt.Errorf("could not load peer CA: %v", err)
+		}
+		peerOpts = append(peerOpts, grpc.WithTransportCredentials(creds))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	go d.reloadOnHangup(ctx)
+	if d.lease != nil {
+		go d.expireLeases(ctx)
+	}
+
+	d.log.Info("starting catalog", "address", cfg.Address, "http_address", cfg.HTTPAddress,
+		"tls", cfg.TLS.CertFile != "", "auth", d.auth != nil, "lease_ttl", cfg.LeaseTTL.String(), "peers", cfg.Peers)
+
+	err = catalog.ServeContext(ctx, cs, catalog.Options{
+		Address:         cfg.Address,
+		GRPCOptions:     grpcOpts,
+		HTTPAddress:     cfg.HTTPAddress,
+		Auth:            d.auth,
+		Origin:          cfg.Origin,
+		Peers:           cfg.Peers,
+		PeerDialOptions: peerOpts,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.log.Info("catalog stopped gracefully")
+	return nil
+}
+
+// newStore returns the store of the catalog, registrations are kept in memory over the static services
+func (d *daemon) newStore() (store.CatalogStore, error) {
+	var static store.CatalogStore
+	if path := d.cfg.Storage.StaticFile; path != "" {
+		var err error
+		d.static, err = store.NewFileCatalogStore(path, store.FileOptions{
+			ReloadInterval: d.cfg.Storage.ReloadInterval,
+			OnReload: func(err error) {
+				if err != nil {
+					d.log.Error("could not reload static services, keeping the current ones", "error", err)
+					return
+				}
+				d.log.Info("reloaded static services", "file", path)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		static = d.static
+	}
+
+	if d.cfg.Storage.StaticEnv {
+		env, err := store.NewEnvCatalogStore(os.Environ())
+		if err != nil {
+			return nil, err
+		}
+		if static != nil {
+			static = store.NewOverlayCatalogStore(env, static)
+		} else {
+			static = env
+		}
+	}
+
+	var cs store.CatalogStore = store.NewMemCatalogStore()
+	if static != nil {
+		cs = store.NewOverlayCatalogStore(cs, static)
+	}
+	return cs, nil
+}
+
+// readConfig loads the configuration file and applies the flags set on the command line
+func readConfig() (Config, error) {
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "address":
+			cfg.Address = *address
+		case "http-address":
+			cfg.HTTPAddress = *httpAddress
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		}
+	})
+
+	return cfg, cfg.validate()
+}
+
+// reloadOnHangup reloads the configuration every time the daemon receives SIGHUP
+func (d *daemon) reloadOnHangup(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-sigs:
+			if err := d.reload(); err != nil {
+				d.log.Error("could not reload config, keeping the current one", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload applies the settings that can change while the catalog is running.
+// Changes to the addresses, storage, log format or enabling TLS and authentication need a restart.
+func (d *daemon) reload() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if cfg.TLS.CertFile != "" && d.cfg.TLS.CertFile != "" {
+		if err := d.loadCertificate(cfg.TLS); err != nil {
+			return err
+		}
+	}
+
+	auth := cfg.authOptions()
+	if (auth == nil) != (d.auth == nil) {
+		d.log.Warn("enabling or disabling authentication requires a restart")
+	} else if auth != nil {
+		if err := d.auth.Update(auth); err != nil {
+			return err
+		}
+	}
+
+	if d.lease != nil && cfg.LeaseTTL > 0 {
+		d.lease.SetTTL(cfg.LeaseTTL)
+	} else if cfg.LeaseTTL != d.cfg.LeaseTTL {
+		d.log.Warn("enabling or disabling leases requires a restart")
+	}
+
+	if cfg.Address != d.cfg.Address || cfg.HTTPAddress != d.cfg.HTTPAddress ||
+		cfg.Storage != d.cfg.Storage || cfg.Log.Format != d.cfg.Log.Format ||
+		(cfg.TLS.CertFile == "") != (d.cfg.TLS.CertFile == "") || cfg.TLS.ClientCAFile != d.cfg.TLS.ClientCAFile ||
+		cfg.TLS.PeerCAFile != d.cfg.TLS.PeerCAFile || cfg.Origin != d.cfg.Origin || !slices.Equal(cfg.Peers, d.cfg.Peers) {
+		d.log.Warn("changes to addresses, storage, log format, TLS settings, origin or peers require a restart")
+	}
+
+	if d.static != nil {
+		if err := d.static.Reload(); err != nil {
+			d.log.Error("could not reload static services", "error", err)
+		}
+	}
+
+	d.level.Set(mustLevel(cfg.Log.Level))
+	d.cfg = cfg
+	d.log.Info("reloaded config")
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the server, whose certificate is reloaded with the config
+func (d *daemon) tlsConfig() (*tls.Config, error) {
+	if err := d.loadCertificate(d.cfg.TLS); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return d.cert.Load(), nil
+		},
+	}
+
+	if d.cfg.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(d.cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", d.cfg.TLS.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func (d *daemon) loadCertificate(cfg TLSConfig) error {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %v", err)
+	}
+	d.cert.Store(&cert)
+	return nil
+}
+
+// expireLeases periodically removes the services whose lease ran out
+func (d *daemon) expireLeases(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, name := range d.lease.Expire() {
+				d.log.Info("service lease expired", "name", name)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logUnaryInterceptor logs every catalog call, failed ones at the warn level
+func (d *daemon) logUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	attrs := []any{
+		"method", info.FullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, "peer", p.Addr.String())
+	}
+	if named, ok := req.(interface{ GetName() string }); ok && named.GetName() != "" {
+		attrs = append(attrs, "name", named.GetName())
+	}
+
+	if err != nil {
+		d.log.Warn("catalog call failed", append(attrs, "error", status.Convert(err).Message())...)
+	} else {
+		d.log.Debug("catalog call", attrs...)
+	}
+	return resp, err
+}
+
+func newLogger(format string, level *slog.LevelVar) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// mustLevel parses a level already checked by Config.validate
+func mustLevel(s string) slog.Level {
+	level, _ := parseLevel(s)
+	return level
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cvhariharan/plugin"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// health runs a gRPC health check against a plugin
+func health(args []string) error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	service := fs.String("service", "", "name of the service to check, the whole server by default")
+	var rf rpcFlags
+	rf.register(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: plugin health [-service name] %s <address>", RPC_FLAGS_USAGE)
+	}
+
+	conn, err := rf.dial(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := rf.context()
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: *service})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(resp.Status)
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// services lists the services a plugin exposes through server reflection
+func services(args []string) error {
+	fs := flag.NewFlagSet("services", flag.ExitOnError)
+	methods := fs.Bool("methods", false, "also list the methods of each service")
+	var rf rpcFlags
+	rf.register(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: plugin services [-methods] %s <address>", RPC_FLAGS_USAGE)
+	}
+
+	conn, err := rf.dial(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := rf.context()
+	defer cancel()
+
+	r, err := newReflector(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	names, err := r.listServices()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+		if !*methods {
+			continue
+		}
+
+		sd, err := r.service(name)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < sd.Methods().Len(); i++ {
+			m := sd.Methods().Get(i)
+			fmt.Printf("  %s(%s) returns (%s)\n", m.Name(), streamName(m.IsStreamingClient(), m.Input()), streamName(m.IsStreamingServer(), m.Output()))
+		}
+	}
+	return nil
+}
+
+// headers collects repeated -H key=value flags
+type headers []string
+
+func (h *headers) String() string { return strings.Join(*h, ",") }
+
+func (h *headers) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("header %q is not in the key=value format", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// RPC_FLAGS_USAGE describes the flags added by rpcFlags in usage messages
+const RPC_FLAGS_USAGE = "[-H key=value] [-token token]"
+
+// rpcFlags are the flags of every subcommand that calls a plugin
+type rpcFlags struct {
+	headers headers
+	token   string
+}
+
+// register adds the -H and -token flags to fs
+func (f *rpcFlags) register(fs *flag.FlagSet) {
+	fs.Var(&f.headers, "H", "metadata sent with the calls as key=value, can be repeated")
+	fs.StringVar(&f.token, "token", "", "bearer token of plugins served with Tokens")
+}
+
+// dial connects to target, sending the token with every call if one is set
+func (f *rpcFlags) dial(target string) (*grpc.ClientConn, error) {
+	var opts []grpc.DialOption
+	if f.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(plugin.TokenCredentials(f.token)))
+	}
+	return dial(target, opts...)
+}
+
+// context returns a context carrying the headers, cancelled after DEFAULT_TIMEOUT
+func (f *rpcFlags) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := timeoutContext()
+	for _, h := range f.headers {
+		k, v, _ := strings.Cut(h, "=")
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	return ctx, cancel
+}
+
+// call invokes a method of a plugin, resolved through server reflection.
+// The request is read as JSON from the last argument, or from stdin if it is "-", with one object per message
+// for client streams. Each response is printed as JSON.
+func call(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	var rf rpcFlags
+	rf.register(fs)
+	fs.Parse(args)
+
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		return fmt.Errorf("usage: plugin call %s <address> <service/method> [json|-]", RPC_FLAGS_USAGE)
+	}
+
+	serviceName, methodName, err := splitMethod(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = strings.NewReader("{}")
+	if fs.Arg(2) == "-" {
+		input = os.Stdin
+	} else if fs.NArg() == 3 {
+		input = strings.NewReader(fs.Arg(2))
+	}
+
+	conn, err := rf.dial(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := rf.context()
+	defer cancel()
+
+	r, err := newReflector(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	sd, err := r.service(serviceName)
+	if err != nil {
+		return err
+	}
+
+	method := sd.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return fmt.Errorf("service %s has no method %s", serviceName, methodName)
+	}
+
+	desc := &grpc.StreamDesc{
+		StreamName:    methodName,
+		ClientStreams: method.IsStreamingClient(),
+		ServerStreams: method.IsStreamingServer(),
+	}
+	stream, err := conn.NewStream(ctx, desc, fmt.Sprintf("/%s/%s", serviceName, methodName))
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(input)
+	for sent := 0; ; sent++ {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			// Unary and server streaming methods take exactly one request, which defaults to an empty message
+			if sent == 0 && !desc.ClientStreams {
+				raw = json.RawMessage("{}")
+			} else {
+				break
+			}
+		} else if err != nil {
+			return fmt.Errorf("invalid JSON input: %v", err)
+		}
+
+		req := dynamicpb.NewMessage(method.Input())
+		if err := protojson.Unmarshal(raw, req); err != nil {
+			return fmt.Errorf("invalid %s: %v", method.Input().FullName(), err)
+		}
+		if err := stream.SendMsg(req); err != nil {
+			return err
+		}
+
+		if !desc.ClientStreams {
+			break
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	marshal := protojson.MarshalOptions{Multiline: true, Resolver: r.types()}
+	for {
+		resp := dynamicpb.NewMessage(method.Output())
+		err := stream.RecvMsg(resp)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		out, err := marshal.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+
+		if !desc.ServerStreams {
+			return nil
+		}
+	}
+}
+
+// splitMethod splits a method given as service/method, /service/method or service.method
+func splitMethod(s string) (string, string, error) {
+	s = strings.TrimPrefix(s, "/")
+	i := strings.LastIndexAny(s, "/.")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", fmt.Errorf("invalid method %q, expected service/method", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func streamName(stream bool, d protoreflect.MessageDescriptor) string {
+	if stream {
+		return "stream " + string(d.FullName())
+	}
+	return string(d.FullName())
+}
+
+// reflector resolves the descriptors of a server through the server reflection service
+type reflector struct {
+	stream rpb.ServerReflection_ServerReflectionInfoClient
+	files  *protoregistry.Files
+	protos map[string]*descriptorpb.FileDescriptorProto
+}
+
+func newReflector(ctx context.Context, conn *grpc.ClientConn) (*reflector, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not use server reflection: %v", err)
+	}
+
+	return &reflector{
+		stream: stream,
+		files:  new(protoregistry.Files),
+		protos: make(map[string]*descriptorpb.FileDescriptorProto),
+	}, nil
+}
+
+func (r *reflector) close() {
+	r.stream.CloseSend()
+}
+
+func (r *reflector) request(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	if err := r.stream.Send(req); err != nil {
+		return nil, fmt.Errorf("could not use server reflection: %v", err)
+	}
+
+	resp, err := r.stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("could not use server reflection: %v", err)
+	}
+
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("server reflection: %s", e.ErrorMessage)
+	}
+	return resp, nil
+}
+
+// listServices returns the sorted names of the services of the server
+func (r *reflector) listServices() ([]string, error) {
+	resp, err := r.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// service returns the descriptor of the named service, fetching the files it is defined in
+func (r *reflector) service(name string) (protoreflect.ServiceDescriptor, error) {
+	if d, err := r.files.FindDescriptorByName(protoreflect.FullName(name)); err == nil {
+		if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+			return sd, nil
+		}
+	}
+
+	resp, err := r.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var root string
+	for i, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := new(descriptorpb.FileDescriptorProto)
+		if err := proto.Unmarshal(b, fd); err != nil {
+			return nil, fmt.Errorf("invalid file descriptor: %v", err)
+		}
+		if i == 0 {
+			root = fd.GetName()
+		}
+		r.protos[fd.GetName()] = fd
+	}
+
+	if err := r.register(root); err != nil {
+		return nil, err
+	}
+
+	d, err := r.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found", name)
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", name)
+	}
+	return sd, nil
+}
+
+// register builds the named file and its dependencies, fetching the ones the server did not send yet
+func (r *reflector) register(name string) error {
+	if _, err := r.files.FindFileByPath(name); err == nil {
+		return nil
+	}
+
+	fd, ok := r.protos[name]
+	if !ok {
+		if _, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+			return nil
+		}
+
+		resp, err := r.request(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			f := new(descriptorpb.FileDescriptorProto)
+			if err := proto.Unmarshal(b, f); err != nil {
+				return fmt.Errorf("invalid file descriptor: %v", err)
+			}
+			r.protos[f.GetName()] = f
+		}
+
+		if fd, ok = r.protos[name]; !ok {
+			return fmt.Errorf("server did not send file %s", name)
+		}
+	}
+
+	for _, dep := range fd.GetDependency() {
+		if err := r.register(dep); err != nil {
+			return err
+		}
+	}
+
+	f, err := protodesc.NewFile(fd, resolver{r.files})
+	if err != nil {
+		return fmt.Errorf("invalid file %s: %v", name, err)
+	}
+	return r.files.RegisterFile(f)
+}
+
+// types returns a resolver for the message types of the fetched files, used to print Any fields
+func (r *reflector) types() *protoregistry.Types {
+	types := new(protoregistry.Types)
+	r.files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		for i := 0; i < f.Messages().Len(); i++ {
+			types.RegisterMessage(dynamicpb.NewMessageType(f.Messages().Get(i)))
+		}
+		return true
+	})
+	return types
+}
+
+// resolver finds files fetched from the server first and then the ones linked into the binary,
+// like the well known types
+type resolver struct {
+	files *protoregistry.Files
+}
+
+func (r resolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if f, err := r.files.FindFileByPath(path); err == nil {
+		return f, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r resolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
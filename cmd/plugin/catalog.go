@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"google.golang.org/grpc"
+)
+
+// catalogCmd lists, gets or removes entries of a catalog server
+func catalogCmd(args []string) error {
+	fs := flag.NewFlagSet("catalog", flag.ExitOnError)
+	address := fs.String("catalog", strings.Split(envOr(plugin.PLUGIN_DISCOVERY_ADDRESS, "localhost:50051"), ",")[0], "address of the catalog server")
+	token := fs.String("token", os.Getenv(plugin.PLUGIN_CATALOG_TOKEN), "bearer token used to remove entries")
+	fs.Parse(args)
+
+	var opts []grpc.DialOption
+	if *token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(plugin.TokenCredentials(*token)))
+	}
+
+	conn, err := dial(*address, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client := protogen.NewCatalogClient(conn)
+	ctx, cancel := timeoutContext()
+	defer cancel()
+
+	switch {
+	case fs.Arg(0) == "list" && fs.NArg() == 1:
+		resp, err := client.List(ctx, &protogen.Empty{})
+		if err != nil {
+			return err
+		}
+		printServices(resp.Services...)
+
+	case fs.Arg(0) == "get" && fs.NArg() == 2:
+		svc, err := client.Get(ctx, &protogen.GetReq{Name: fs.Arg(1)})
+		if err != nil {
+			return err
+		}
+		printServices(svc)
+
+	case fs.Arg(0) == "remove" && fs.NArg() == 2:
+		if _, err := client.Remove(ctx, &protogen.GetReq{Name: fs.Arg(1)}); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("usage: plugin catalog [-catalog address] [-token token] list|get <name>|remove <name>")
+	}
+
+	return nil
+}
+
+func printServices(services ...*protogen.Service) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOCKET\tADDRESS\tORIGIN")
+	for _, svc := range services {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", svc.Name, svc.SocketType, svc.Address, svc.Origin)
+	}
+	w.Flush()
+}
+
+// envOr returns the environment variable key, or def if it is not set
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/cvhariharan/plugin"
+)
+
+// launch starts a plugin binary the way a host does, prints its handshake and stops it,
+// or keeps it running until interrupted with -keep
+func launch(args []string) error {
+	fs := flag.NewFlagSet("launch", flag.ExitOnError)
+	socketType := fs.String("socket", plugin.SOCKET_TYPE_UNIX, "socket type the plugin listens on, unix or tcp")
+	keep := fs.Bool("keep", false, "keep the plugin running until interrupted")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: plugin launch [-socket unix|tcp] [-keep] <path> [args...]")
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", plugin.PLUGIN_SOCKET_TYPE, *socketType),
+		fmt.Sprintf("%s=%d", plugin.PLUGIN_MIN_PORT, plugin.MIN_PORT),
+		fmt.Sprintf("%s=%d", plugin.PLUGIN_MAX_PORT, plugin.MAX_PORT),
+	)
+
+	if *socketType == plugin.SOCKET_TYPE_UNIX {
+		dir, err := os.MkdirTemp("", "plugin-")
+		if err != nil {
+			return fmt.Errorf("could not create socket directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", plugin.PLUGIN_SOCKET_DIR, dir))
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error creating stdout pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not launch plugin %s: %v", fs.Arg(0), err)
+	}
+	defer func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	if !scanner.Scan() {
+		return fmt.Errorf("plugin exited without a handshake")
+	}
+
+	var resp plugin.PluginResponse
+	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+		return fmt.Errorf("invalid handshake %q: %v", scanner.Text(), err)
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "  ")
+	fmt.Println(string(out))
+
+	if *keep {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		<-sigs
+	}
+	return nil
+}
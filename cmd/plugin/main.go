@@ -0,0 +1,98 @@
+// Command plugin inspects and invokes plugins and the catalog.
+//
+//	plugin launch [-socket unix|tcp] [-keep] <path> [args...]
+//	plugin catalog [-catalog address] [-token token] list|get <name>|remove <name>
+//	plugin health [-service name] [-H key=value] [-token token] <address>
+//	plugin services [-methods] [-H key=value] [-token token] <address>
+//	plugin call [-H key=value] [-token token] <address> <service/method> [json|-]
+//
+// Addresses are gRPC targets, so unix sockets are given as unix:///path or simply /path.
+// Commands that call a plugin send the -H metadata and the -token bearer token, needed by plugins served with Tokens.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// DEFAULT_TIMEOUT is the default timeout of commands that make gRPC calls
+const DEFAULT_TIMEOUT = 10 * time.Second
+
+// exitCode is returned by commands that already reported their result and only need to set the exit status
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+var commands = map[string]func(args []string) error{
+	"launch":   launch,
+	"catalog":  catalogCmd,
+	"health":   health,
+	"services": services,
+	"call":     call,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(flag.Args()[1:]); err != nil {
+		var code exitCode
+		if errors.As(err, &code) {
+			os.Exit(int(code))
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: plugin <command> [flags] [args]
+
+commands:
+  launch    start a plugin binary and print its handshake
+  catalog   list, get or remove catalog entries
+  health    check the health of a running plugin
+  services  list the services of a running plugin
+  call      invoke an RPC on a running plugin with JSON input and output
+`)
+}
+
+// dial connects to target, which can also be the path of a unix socket
+func dial(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if strings.HasPrefix(target, "/") {
+		target = "unix://" + target
+	}
+
+	opts = append([]grpc.DialOption{grpc.WithInsecure()}, opts...)
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %v", target, err)
+	}
+	return conn, nil
+}
+
+// timeoutContext returns a context cancelled after DEFAULT_TIMEOUT
+func timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), DEFAULT_TIMEOUT)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cvhariharan/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type pinger struct{}
+
+func (pinger) Ping() string {
+	return "pong"
+}
+
+// servePinger serves pinger with a token on a unix socket until the end of the test and returns its address
+func servePinger(t *testing.T, token string) string {
+	t.Helper()
+	t.Setenv(plugin.PLUGIN_SOCKET_TYPE, plugin.SOCKET_TYPE_UNIX)
+	t.Setenv(plugin.PLUGIN_SOCKET_DIR, t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	t.Cleanup(func() {
+		cancel()
+		<-errc
+	})
+
+	out := capture(t, func() error {
+		go func() {
+			errc <- plugin.ServeContext(ctx, &plugin.DynamicPlugin[pinger]{}, plugin.PluginServeOptions{Name: "pinger", Tokens: []string{token}})
+		}()
+		return nil
+	}, 1)
+
+	var resp plugin.PluginResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("invalid handshake %q: %v", out, err)
+	}
+	return resp.Address
+}
+
+// capture runs fn with stdout redirected and returns the first lines printed, all of them if lines is 0
+func capture(t *testing.T, fn func() error, lines int) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	if err := fn(); err != nil {
+		w.Close()
+		t.Fatal(err)
+	}
+
+	if lines == 0 {
+		w.Close()
+		b, _ := io.ReadAll(r)
+		return string(b)
+	}
+
+	var out []string
+	scanner := bufio.NewScanner(r)
+	for len(out) < lines && scanner.Scan() {
+		out = append(out, scanner.Text())
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestHealth(t *testing.T) {
+	addr := servePinger(t, "secret")
+
+	out := capture(t, func() error { return health([]string{addr}) }, 0)
+	if strings.TrimSpace(out) != "SERVING" {
+		t.Fatalf("expected SERVING, got %q", out)
+	}
+}
+
+func TestServicesNeedToken(t *testing.T) {
+	addr := servePinger(t, "secret")
+
+	if err := services([]string{addr}); err == nil || !strings.Contains(err.Error(), "Unauthenticated") {
+		t.Fatalf("expected services to fail without a token, got %v", err)
+	}
+
+	out := capture(t, func() error { return services([]string{"-token", "secret", "-methods", addr}) }, 0)
+	if !strings.Contains(out, "plugin.Dynamic\n  Call(") {
+		t.Fatalf("expected the Dynamic service and its methods, got %q", out)
+	}
+}
+
+func TestCall(t *testing.T) {
+	addr := servePinger(t, "secret")
+
+	if err := call([]string{addr, "plugin.Dynamic/Call", `{"method": "Ping"}`}); err == nil || !strings.Contains(err.Error(), "Unauthenticated") {
+		t.Fatalf("expected call to fail without a token, got %v", err)
+	}
+
+	out := capture(t, func() error {
+		return call([]string{"-token", "secret", "-H", "x-request-id=1", addr, "plugin.Dynamic/Call", `{"method": "Ping"}`})
+	}, 0)
+	if !strings.Contains(out, `"results"`) {
+		t.Fatalf("expected the results of Ping, got %q", out)
+	}
+
+	if err := call([]string{"-token", "secret", addr, "plugin.Dynamic/Missing"}); err == nil || !strings.Contains(err.Error(), "no method") {
+		t.Fatalf("expected an unknown method to be reported, got %v", err)
+	}
+}
+
+func TestHealthUnknownService(t *testing.T) {
+	addr := servePinger(t, "secret")
+
+	var err error
+	capture(t, func() error {
+		err = health([]string{"-service", "plugin.Dynamic", addr})
+		return nil
+	}, 0)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected an unknown service to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultMetadataKeys are the metadata keys propagated across plugin calls when no keys are configured.
+// Credentials like authorization are not forwarded unless they are added to MetadataKeys,
+// since a plugin would otherwise receive the credentials the host was called with.
+var DefaultMetadataKeys = []string{"x-request-id"}
+
+// WithMetadata returns a copy of ctx that sends the key value pair as metadata on plugin calls.
+// Deadlines and cancellation of ctx are propagated by gRPC.
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, key, value)
+}
+
+// MetadataValue returns the first value of key in the metadata sent by the caller of a plugin method
+func MetadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// propagateMetadata copies the whitelisted keys from the incoming metadata of ctx to its outgoing metadata,
+// so that a plugin call made while serving a request carries the metadata of that request.
+// Keys already present in the outgoing metadata are left as is.
+func propagateMetadata(ctx context.Context, keys []string) context.Context {
+	in, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	out, _ := metadata.FromOutgoingContext(ctx)
+	out = out.Copy()
+
+	var changed bool
+	for _, k := range keys {
+		k = strings.ToLower(k)
+		if len(out.Get(k)) > 0 {
+			continue
+		}
+		if v := in.Get(k); len(v) > 0 {
+			out.Set(k, v...)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+func metadataKeys(keys []string) []string {
+	if keys == nil {
+		return DefaultMetadataKeys
+	}
+	return keys
+}
+
+// propagateUnaryClientInterceptor forwards the whitelisted metadata of the request being served, if any
+func propagateUnaryClientInterceptor(keys []string) grpc.UnaryClientInterceptor {
+	keys = metadataKeys(keys)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(propagateMetadata(ctx, keys), method, req, reply, cc, opts...)
+	}
+}
+
+// propagateStreamClientInterceptor is the stream counterpart of propagateUnaryClientInterceptor
+func propagateStreamClientInterceptor(keys []string) grpc.StreamClientInterceptor {
+	keys = metadataKeys(keys)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(propagateMetadata(ctx, keys), desc, cc, method, opts...)
+	}
+}
+
+// propagateUnaryServerInterceptor sets the whitelisted incoming metadata as outgoing metadata on the
+// handler context, so that calls made by the plugin with that context carry it to the next service
+func propagateUnaryServerInterceptor(keys []string) grpc.UnaryServerInterceptor {
+	keys = metadataKeys(keys)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(propagateMetadata(ctx, keys), req)
+	}
+}
+
+// propagateStreamServerInterceptor is the stream counterpart of propagateUnaryServerInterceptor
+func propagateStreamServerInterceptor(keys []string) grpc.StreamServerInterceptor {
+	keys = metadataKeys(keys)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: propagateMetadata(ss.Context(), keys)})
+	}
+}
+
+// contextServerStream overrides the context of a grpc.ServerStream
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
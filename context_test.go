@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPropagateMetadata(t *testing.T) {
+	in := metadata.Pairs("x-request-id", "42", "authorization", "Bearer secret")
+	ctx := metadata.NewIncomingContext(context.Background(), in)
+
+	out, _ := metadata.FromOutgoingContext(propagateMetadata(ctx, DefaultMetadataKeys))
+	if v := out.Get("x-request-id"); len(v) != 1 || v[0] != "42" {
+		t.Fatalf("expected the request ID to be forwarded, got %v", v)
+	}
+	if v := out.Get("authorization"); len(v) != 0 {
+		t.Fatalf("expected credentials not to be forwarded by default, got %v", v)
+	}
+
+	out, _ = metadata.FromOutgoingContext(propagateMetadata(ctx, []string{"Authorization"}))
+	if v := out.Get("authorization"); len(v) != 1 {
+		t.Fatalf("expected credentials to be forwarded when asked, got %v", v)
+	}
+
+	// Keys set by the caller take precedence
+	ctx = WithMetadata(ctx, "x-request-id", "43")
+	out, _ = metadata.FromOutgoingContext(propagateMetadata(ctx, DefaultMetadataKeys))
+	if v := out.Get("x-request-id"); len(v) != 1 || v[0] != "43" {
+		t.Fatalf("expected the outgoing request ID to be kept, got %v", v)
+	}
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// healthMethodPrefix is the prefix of the health service methods, which the host calls without credentials
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// tokenCredentials sends a bearer token in the authorization metadata of every call
+type tokenCredentials struct {
+	token string
+}
+
+// TokenCredentials returns per-RPC credentials sending token as a bearer token.
+// They do not require transport security, so the token is sent in the clear unless TLS is set in the dial options.
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token}
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// checkToken checks that ctx carries one of tokens as a bearer token
+func checkToken(ctx context.Context, tokens []string) error {
+	token, ok := strings.CutPrefix(MetadataValue(ctx, "authorization"), "Bearer ")
+	if ok {
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing token")
+}
+
+// tokenUnaryServerInterceptor rejects calls without a valid token, except health checks
+func tokenUnaryServerInterceptor(tokens []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			if err := checkToken(ctx, tokens); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tokenStreamServerInterceptor rejects streams without a valid token, except health checks
+func tokenStreamServerInterceptor(tokens []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			if err := checkToken(ss.Context(), tokens); err != nil {
+				return err
+			}
+		}
+		return handler(srv, ss)
+	}
+}
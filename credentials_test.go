@@ -0,0 +1,38 @@
+package plugin_test
+
+import (
+	"testing"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func loadBankWithToken(t *testing.T, token string) *plugin.DynamicClient {
+	t.Helper()
+
+	opt := plugin.PluginLoadOptions{
+		Name:         "bank",
+		InProcess:    true,
+		Plugin:       &plugin.DynamicPlugin[*Bank]{Impl: &Bank{accounts: make(map[string]Account)}},
+		ServeOptions: plugin.PluginServeOptions{Tokens: []string{"secret"}},
+	}
+	if token != "" {
+		opt.PerRPCCredentials = plugin.TokenCredentials(token)
+	}
+	return plugintest.LoadPlugin(t, plugintest.NewFakeCatalogStore(), opt).(*plugin.DynamicClient)
+}
+
+func TestTokenCredentials(t *testing.T) {
+	if _, err := loadBankWithToken(t, "secret").Call("Count"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		_, err := loadBankWithToken(t, token).Call("Count")
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected token %q to be rejected, got %v", token, err)
+		}
+	}
+}
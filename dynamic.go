@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/cvhariharan/plugin/protogen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+var (
+	dispensersMu sync.RWMutex
+	dispensers   = make(map[reflect.Type]interface{})
+)
+
+func init() {
+	// Register the builtin types so they can be passed as arguments and results
+	// of dynamic calls without any setup from the plugin author
+	for _, v := range []interface{}{
+		"", false, []byte{}, []string{}, map[string]string{},
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+	} {
+		RegisterType(v)
+	}
+}
+
+// DynamicPlugin exposes Impl over the built-in Dynamic gRPC service.
+// Methods are dispatched by name using reflection, so no proto definitions are
+// needed. Arguments and results are encoded with the TypeRegistry, which means
+// any non builtin type used in the interface must be registered with RegisterType.
+// A context.Context first parameter is not sent, it receives the context of the call,
+// including the deadline and the metadata passed to DynamicClient.CallContext.
+//
+// The host gets a DynamicClient, Go cannot build an implementation of the interface at runtime.
+// Using the interface on the host needs a hand-written adapter, see RegisterDispenser.
+type DynamicPlugin[T any] struct {
+	Impl T
+}
+
+func (d *DynamicPlugin[T]) Client(conn *grpc.ClientConn) (interface{}, error) {
+	return &DynamicClient{client: protogen.NewDynamicClient(conn)}, nil
+}
+
+func (d *DynamicPlugin[T]) Server(srv *grpc.Server) error {
+	impl := reflect.ValueOf(d.Impl)
+	if !impl.IsValid() {
+		return fmt.Errorf("dynamic plugin has no implementation")
+	}
+
+	protogen.RegisterDynamicServer(srv, &dynamicServer{impl: impl})
+	return nil
+}
+
+// DynamicClient calls methods on a DynamicPlugin by name
+type DynamicClient struct {
+	client protogen.DynamicClient
+}
+
+// Call invokes the named method with args and returns its results.
+// If the last result of the method is an error, it is returned as err and not included in the results.
+// Errors are reconstructed with FromStatus, so errors.Is and errors.As work for registered errors.
+func (d *DynamicClient) Call(method string, args ...interface{}) ([]interface{}, error) {
+	return d.CallContext(context.Background(), method, args...)
+}
+
+// CallContext is like Call but uses ctx for the underlying RPC
+func (d *DynamicClient) CallContext(ctx context.Context, method string, args ...interface{}) ([]interface{}, error) {
+	req := &protogen.CallReq{Method: method}
+	for _, arg := range args {
+		v, err := encodeValue(reflect.ValueOf(arg))
+		if err != nil {
+			return nil, fmt.Errorf("could not encode argument for %s: %v", method, err)
+		}
+		req.Args = append(req.Args, v)
+	}
+
+	resp, err := d.client.Call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, 0, len(resp.Results))
+	for _, v := range resp.Results {
+		r, err := decodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode result of %s: %v", method, err)
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// RegisterDispenser registers a function that adapts a DynamicClient to the interface T.
+// Go cannot create new method sets at runtime, so each interface served by a
+// DynamicPlugin needs a small adapter that forwards its methods to DynamicClient.Call.
+func RegisterDispenser[T any](fn func(*DynamicClient) T) {
+	dispensersMu.Lock()
+	defer dispensersMu.Unlock()
+	dispensers[reflect.TypeOf((*T)(nil)).Elem()] = fn
+}
+
+// Dispense converts a client returned by Load to T.
+// If the client already implements T it is returned as is, otherwise a
+// DynamicClient is adapted using the dispenser registered for T.
+func Dispense[T any](c interface{}) (T, error) {
+	var zero T
+	if t, ok := c.(T); ok {
+		return t, nil
+	}
+
+	dc, ok := c.(*DynamicClient)
+	if !ok {
+		return zero, fmt.Errorf("plugin client %T does not implement %s", c, reflect.TypeOf((*T)(nil)).Elem())
+	}
+
+	dispensersMu.RLock()
+	fn, ok := dispensers[reflect.TypeOf((*T)(nil)).Elem()]
+	dispensersMu.RUnlock()
+	if !ok {
+		return zero, fmt.Errorf("no dispenser registered for %s", reflect.TypeOf((*T)(nil)).Elem())
+	}
+
+	return fn.(func(*DynamicClient) T)(dc), nil
+}
+
+// dynamicServer dispatches Dynamic calls to the methods of impl
+type dynamicServer struct {
+	protogen.UnimplementedDynamicServer
+	impl reflect.Value
+}
+
+func (s *dynamicServer) Call(ctx context.Context, req *protogen.CallReq) (*protogen.CallResp, error) {
+	m := s.impl.MethodByName(req.Method)
+	if !m.IsValid() {
+		return nil, status.Errorf(codes.Unimplemented, "method %s not found", req.Method)
+	}
+
+	mt := m.Type()
+	if mt.IsVariadic() {
+		return nil, status.Errorf(codes.Unimplemented, "method %s is variadic, which is not supported", req.Method)
+	}
+
+	// The context of the call is passed in place of a leading context.Context, it is not sent by the client
+	args := make([]reflect.Value, 0, mt.NumIn())
+	if mt.NumIn() > 0 && mt.In(0) == contextType {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+
+	if n := mt.NumIn() - len(args); n != len(req.Args) {
+		return nil, status.Errorf(codes.InvalidArgument, "method %s expects %d arguments, got %d", req.Method, n, len(req.Args))
+	}
+
+	for i, v := range req.Args {
+		arg, err := decodeArg(v, mt.In(len(args)))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid argument %d for %s: %v", i, req.Method, err)
+		}
+		args = append(args, arg)
+	}
+
+	out := m.Call(args)
+
+	// The error is converted to a status by the server interceptor
+	if n := len(out); n > 0 && mt.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		out = out[:n-1]
+	}
+
+	var resp protogen.CallResp
+	for _, o := range out {
+		v, err := encodeValue(o)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not encode result of %s: %v", req.Method, err)
+		}
+		resp.Results = append(resp.Results, v)
+	}
+
+	return &resp, nil
+}
+
+// encodeValue serializes v using the TypeRegistry. Nil values are encoded with an empty type name.
+func encodeValue(v reflect.Value) (*protogen.Value, error) {
+	if !v.IsValid() {
+		return &protogen.Value{}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return &protogen.Value{}, nil
+		}
+	}
+
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	b, t, err := SerializeObject(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return &protogen.Value{Data: b, TypeName: t, Pointer: v.Kind() == reflect.Ptr}, nil
+}
+
+// decodeValue deserializes v, returning a pointer only if the original value was one
+func decodeValue(v *protogen.Value) (interface{}, error) {
+	if v.TypeName == "" {
+		return nil, nil
+	}
+
+	obj, err := DeserializeObject(v.Data, v.TypeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Pointer {
+		return obj, nil
+	}
+	return reflect.ValueOf(obj).Elem().Interface(), nil
+}
+
+// decodeArg deserializes v into a value assignable to t
+func decodeArg(v *protogen.Value, t reflect.Type) (reflect.Value, error) {
+	if v.TypeName == "" {
+		return reflect.Zero(t), nil
+	}
+
+	obj, err := DeserializeObject(v.Data, v.TypeName)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	rv := reflect.ValueOf(obj)
+	if rv.Type().AssignableTo(t) {
+		return rv, nil
+	}
+	if rv.Elem().Type().AssignableTo(t) {
+		return rv.Elem(), nil
+	}
+	if rv.Elem().Type().ConvertibleTo(t) {
+		return rv.Elem().Convert(t), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", v.TypeName, t)
+}
@@ -0,0 +1,151 @@
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+)
+
+type Account struct {
+	Name    string
+	Balance int
+}
+
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("account %s not found", e.Name)
+}
+
+var ErrFrozen = errors.New("account is frozen")
+
+type Bank struct {
+	accounts map[string]Account
+}
+
+func (b *Bank) Open(name string, balance int) (*Account, error) {
+	a := Account{Name: name, Balance: balance}
+	b.accounts[name] = a
+	return &a, nil
+}
+
+func (b *Bank) Get(name string) (Account, error) {
+	a, ok := b.accounts[name]
+	if !ok {
+		return Account{}, &NotFoundError{Name: name}
+	}
+	return a, nil
+}
+
+func (b *Bank) Withdraw(name string, amount int) error {
+	return fmt.Errorf("could not withdraw %d from %s: %w", amount, name, ErrFrozen)
+}
+
+// HasDeadline reports whether the context of the call has a deadline
+func (b *Bank) HasDeadline(ctx context.Context, name string) (bool, error) {
+	if _, err := b.Get(name); err != nil {
+		return false, err
+	}
+	_, ok := ctx.Deadline()
+	return ok, nil
+}
+
+func (b *Bank) Count() int {
+	return len(b.accounts)
+}
+
+type BankClient interface {
+	Count() (int, error)
+}
+
+type bankProxy struct {
+	c *plugin.DynamicClient
+}
+
+func (p *bankProxy) Count() (int, error) {
+	out, err := p.c.Call("Count")
+	if err != nil {
+		return 0, err
+	}
+	return out[0].(int), nil
+}
+
+func init() {
+	plugin.RegisterType(Account{})
+	plugin.RegisterType(&NotFoundError{})
+	plugin.RegisterError(ErrFrozen)
+	plugin.RegisterDispenser(func(c *plugin.DynamicClient) BankClient { return &bankProxy{c} })
+}
+
+func loadBank(t *testing.T) *plugin.DynamicClient {
+	t.Helper()
+	bank := &Bank{accounts: make(map[string]Account)}
+	return plugintest.LoadInProcess(t, "bank", &plugin.DynamicPlugin[*Bank]{Impl: bank}).(*plugin.DynamicClient)
+}
+
+func TestDynamicCall(t *testing.T) {
+	c := loadBank(t)
+
+	out, err := c.Call("Open", "alice", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := out[0].(*Account); !ok || a.Name != "alice" || a.Balance != 10 {
+		t.Fatalf("expected a pointer to alice's account, got %#v", out[0])
+	}
+
+	out, err = c.Call("Get", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := out[0].(Account); !ok || a.Balance != 10 {
+		t.Fatalf("expected alice's account, got %#v", out[0])
+	}
+}
+
+func TestDispense(t *testing.T) {
+	c := loadBank(t)
+	if _, err := c.Call("Open", "alice", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	bank, err := plugin.Dispense[BankClient](c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bank.Count(); err != nil || n != 1 {
+		t.Fatalf("expected 1 account, got %d, %v", n, err)
+	}
+
+	if _, err := plugin.Dispense[fmt.Stringer](c); err == nil {
+		t.Fatal("expected Dispense to fail without a dispenser")
+	}
+}
+
+func TestDynamicCallContext(t *testing.T) {
+	c := loadBank(t)
+	if _, err := c.Call("Open", "alice", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := c.CallContext(ctx, "HasDeadline", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out[0].(bool) {
+		t.Fatal("expected the context parameter to receive the deadline of the call")
+	}
+
+	if out, err = c.Call("HasDeadline", "alice"); err != nil || out[0].(bool) {
+		t.Fatalf("expected no deadline without one on the call, got %v, %v", out, err)
+	}
+}
@@ -0,0 +1,209 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/cvhariharan/plugin/protogen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	sentinelsMu sync.RWMutex
+	sentinels   []error
+)
+
+// RegisterError registers a sentinel error so that errors.Is keeps working on the host
+// for errors returned by a plugin. Sentinels are matched by their message, so the same
+// error has to be registered on both sides of the plugin boundary.
+func RegisterError(err error) {
+	sentinelsMu.Lock()
+	defer sentinelsMu.Unlock()
+	sentinels = append(sentinels, err)
+}
+
+// RemoteError is an error returned by a plugin that was reconstructed from its gRPC status.
+// It unwraps to the registered sentinel and the registered error type payload, if any.
+type RemoteError struct {
+	status   *status.Status
+	sentinel error
+	payload  error
+}
+
+func (e *RemoteError) Error() string {
+	return e.status.Message()
+}
+
+// Code returns the gRPC status code of the error
+func (e *RemoteError) Code() codes.Code {
+	return e.status.Code()
+}
+
+func (e *RemoteError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+func (e *RemoteError) Unwrap() []error {
+	var errs []error
+	if e.payload != nil {
+		errs = append(errs, e.payload)
+	}
+	if e.sentinel != nil {
+		errs = append(errs, e.sentinel)
+	}
+	return errs
+}
+
+// ToStatus converts err to a gRPC status error carrying an ErrorDetail.
+// The status code is taken from err if it wraps a status or a context error, otherwise it is codes.Unknown.
+// The first error in the chain whose type is registered with RegisterType is sent as the payload.
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// Already converted, e.g. by a handler that called ToStatus itself
+	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		for _, d := range se.GRPCStatus().Details() {
+			if _, ok := d.(*protogen.ErrorDetail); ok {
+				return err
+			}
+		}
+	}
+
+	code := status.Code(err)
+	if code == codes.Unknown {
+		code = status.FromContextError(err).Code()
+	}
+
+	s, detailErr := status.New(code, err.Error()).WithDetails(errorDetail(err, code))
+	if detailErr != nil {
+		return status.Error(code, err.Error())
+	}
+	return s.Err()
+}
+
+// errorDetail builds the ErrorDetail describing err
+func errorDetail(err error, code codes.Code) *protogen.ErrorDetail {
+	detail := &protogen.ErrorDetail{Message: err.Error(), Code: uint32(code)}
+
+	sentinelsMu.RLock()
+	for _, s := range sentinels {
+		if errors.Is(err, s) {
+			detail.Sentinel = s.Error()
+			break
+		}
+	}
+	sentinelsMu.RUnlock()
+
+	if payload := findRegisteredError(err); payload != nil {
+		if v, encErr := encodeValue(reflect.ValueOf(payload)); encErr == nil {
+			detail.Payload = v
+		}
+	}
+
+	return detail
+}
+
+// FromStatus reconstructs an error converted with ToStatus.
+// Errors without an ErrorDetail are returned unchanged.
+func FromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok || s == nil {
+		return err
+	}
+
+	for _, d := range s.Details() {
+		detail, ok := d.(*protogen.ErrorDetail)
+		if !ok {
+			continue
+		}
+
+		return fromErrorDetail(s, detail)
+	}
+
+	return err
+}
+
+// fromErrorDetail reconstructs the error described by detail
+func fromErrorDetail(s *status.Status, detail *protogen.ErrorDetail) *RemoteError {
+	re := &RemoteError{status: s}
+	if detail.Sentinel != "" {
+		sentinelsMu.RLock()
+		for _, sentinel := range sentinels {
+			if sentinel.Error() == detail.Sentinel {
+				re.sentinel = sentinel
+				break
+			}
+		}
+		sentinelsMu.RUnlock()
+	}
+
+	if detail.Payload != nil {
+		if v, decErr := decodeValue(detail.Payload); decErr == nil {
+			re.payload, _ = v.(error)
+		}
+	}
+
+	return re
+}
+
+// findRegisteredError returns the first error in the chain of err whose type is in the TypeRegistry
+func findRegisteredError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if isRegistered(reflect.TypeOf(err)) {
+		return err
+	}
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		return findRegisteredError(u.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, e := range u.Unwrap() {
+			if found := findRegisteredError(e); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// errorUnaryServerInterceptor converts errors returned by plugin handlers with ToStatus
+func errorUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, ToStatus(err)
+}
+
+// errorStreamServerInterceptor converts errors returned by plugin stream handlers with ToStatus
+func errorStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return ToStatus(handler(srv, ss))
+}
+
+// errorUnaryClientInterceptor reconstructs errors returned by plugins with FromStatus
+func errorUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return FromStatus(invoker(ctx, method, req, reply, cc, opts...))
+}
+
+// errorStreamClientInterceptor reconstructs errors received on plugin streams with FromStatus
+func errorStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, FromStatus(err)
+	}
+	return &errorClientStream{ClientStream: s}, nil
+}
+
+type errorClientStream struct {
+	grpc.ClientStream
+}
+
+func (s *errorClientStream) RecvMsg(m interface{}) error {
+	return FromStatus(s.ClientStream.RecvMsg(m))
+}
@@ -0,0 +1,56 @@
+package plugin_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cvhariharan/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDynamicCallErrors(t *testing.T) {
+	c := loadBank(t)
+
+	_, err := c.Call("Get", "bob")
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) || notFound.Name != "bob" {
+		t.Fatalf("expected a NotFoundError for bob, got %v", err)
+	}
+
+	_, err = c.Call("Withdraw", "alice", 5)
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("expected ErrFrozen, got %v", err)
+	}
+	if err.Error() != "could not withdraw 5 from alice: account is frozen" {
+		t.Fatalf("unexpected error message %q", err)
+	}
+
+	_, err = c.Call("Close")
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented for an unknown method, got %v", err)
+	}
+
+	_, err = c.Call("Get")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a missing argument, got %v", err)
+	}
+}
+
+func TestStatusRoundTrip(t *testing.T) {
+	err := plugin.FromStatus(plugin.ToStatus(fmt.Errorf("lookup failed: %w", &NotFoundError{Name: "carol"})))
+
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) || notFound.Name != "carol" {
+		t.Fatalf("expected a NotFoundError for carol, got %v", err)
+	}
+	if status.Code(err) != codes.Unknown {
+		t.Fatalf("expected Unknown, got %s", status.Code(err))
+	}
+
+	err = plugin.FromStatus(plugin.ToStatus(status.Error(codes.NotFound, "gone")))
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the status code to be kept, got %s", status.Code(err))
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"sync"
+	"time"
 
 	"github.com/cvhariharan/plugin"
 	"github.com/cvhariharan/plugin/catalog"
@@ -15,14 +16,14 @@ func main() {
 
 	// Start the discovery server to catalog remote plugins
 	cs := store.NewMemCatalogStore()
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		if err := catalog.Serve(cs, ":50051"); err != nil {
-			log.Println(err)
-		}
-		wg.Done()
-	}()
+	srv, err := catalog.NewServer(cs, catalog.Options{Address: ":50051"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Stop(context.Background())
 
 	c, err := plugin.Load(
 		plugin.PluginLoadOptions{
@@ -42,8 +43,11 @@ func main() {
 	// cast to the interface we expect
 	client := c.(hello.Hello)
 
-	// use the client just like any normal object
-	fmt.Println(client.Greet())
+	// deadlines and whitelisted metadata on the context are propagated to the plugin
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = plugin.WithMetadata(ctx, "x-request-id", "1234")
 
-	wg.Wait()
+	// use the client just like any normal object
+	fmt.Println(client.Greet(ctx))
 }
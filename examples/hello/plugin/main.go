@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 
 	"github.com/cvhariharan/plugin"
@@ -14,11 +16,14 @@ const Name = "hello"
 // This is the actual implementation
 type HelloImpl struct{}
 
-func (hi *HelloImpl) Greet() string {
-	return "Hello World!"
+func (hi *HelloImpl) Greet(ctx context.Context) (string, error) {
+	if id := plugin.MetadataValue(ctx, "x-request-id"); id != "" {
+		return fmt.Sprintf("Hello World! (request %s)", id), nil
+	}
+	return "Hello World!", nil
 }
 
 func main() {
 	p := &shared.HelloPlugin{Impl: &HelloImpl{}}
-	log.Fatal(plugin.Serve(p, plugin.PluginServeOptions{Name: Name}))
+	log.Fatal(plugin.Serve(p, plugin.PluginServeOptions{Name: Name, Version: "1.0.0"}))
 }
@@ -10,7 +10,7 @@ import (
 
 // This is the main business logic interface
 type Hello interface {
-	Greet() string
+	Greet(ctx context.Context) (string, error)
 }
 
 type HelloPlugin struct {
@@ -37,12 +37,12 @@ type HelloClient struct {
 	client protos.HelloClient
 }
 
-func (hc *HelloClient) Greet() string {
-	resp, err := hc.client.Greet(context.Background(), &protos.Empty{})
+func (hc *HelloClient) Greet(ctx context.Context) (string, error) {
+	resp, err := hc.client.Greet(ctx, &protos.Empty{})
 	if err != nil {
-		return ""
+		return "", err
 	}
-	return resp.GetHello()
+	return resp.GetHello(), nil
 }
 
 // This is the gRPC server that will internally call the actual implementation
@@ -52,6 +52,9 @@ type HelloServer struct {
 }
 
 func (hs *HelloServer) Greet(ctx context.Context, e *protos.Empty) (*protos.Resp, error) {
-	r := hs.Impl.Greet()
+	r, err := hs.Impl.Greet(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return &protos.Resp{Hello: r}, nil
 }
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"sync"
 
 	"github.com/cvhariharan/plugin"
 	"github.com/cvhariharan/plugin/catalog"
@@ -15,14 +15,14 @@ func main() {
 
 	// Start the discovery server to catalog remote plugins
 	cs := store.NewMemCatalogStore()
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		if err := catalog.Serve(cs, ":50051"); err != nil {
-			log.Println(err)
-		}
-		wg.Done()
-	}()
+	srv, err := catalog.NewServer(cs, catalog.Options{Address: ":50051"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Stop(context.Background())
 
 	c, err := plugin.Load(
 		plugin.PluginLoadOptions{
@@ -44,7 +44,5 @@ func main() {
 
 	t := &test.TestObj{Data: "some test data"}
 	// use the client just like any normal object
-	fmt.Println(client.TestCall(t))
-
-	wg.Wait()
+	fmt.Println(client.TestCall(context.Background(), t))
 }
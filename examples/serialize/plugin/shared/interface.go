@@ -3,7 +3,6 @@ package shared
 import (
 	"context"
 	"fmt"
-	"log"
 
 	pb "github.com/cvhariharan/plugin/example/serialize/plugin/protos"
 
@@ -12,7 +11,7 @@ import (
 )
 
 type Test interface {
-	TestCall(*TestObj) string
+	TestCall(context.Context, *TestObj) (string, error)
 }
 
 type TestPlugin struct {
@@ -35,20 +34,18 @@ type TestClient struct {
 	client pb.TestClient
 }
 
-func (tc *TestClient) TestCall(o *TestObj) string {
+func (tc *TestClient) TestCall(ctx context.Context, o *TestObj) (string, error) {
 	b, t, err := plugin.SerializeObject(o)
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", err
 	}
 
-	r, err := tc.client.TestCall(context.Background(), &pb.Obj{SerializedObjects: b, TypeName: t})
+	r, err := tc.client.TestCall(ctx, &pb.Obj{SerializedObjects: b, TypeName: t})
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", err
 	}
 
-	return r.Response
+	return r.Response, nil
 }
 
 type TestServer struct {
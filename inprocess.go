@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// IN_PROCESS_BUFFER_SIZE is the size of the in-memory buffer between the host and an in-process plugin
+const IN_PROCESS_BUFFER_SIZE = 1024 * 1024
+
+// loadInProcess serves opt.Plugin on an in-memory listener in the host process and connects to it.
+// The plugin goes through the same Server and Client code, interceptors and codecs as a plugin
+// running in a subprocess, but no executable or socket is needed.
+func loadInProcess(opt PluginLoadOptions, t *telemetry) (*instance, error) {
+	lis := bufconn.Listen(IN_PROCESS_BUFFER_SIZE)
+
+	serveOpt := opt.ServeOptions
+	if serveOpt.Name == "" {
+		serveOpt.Name = opt.Name
+	}
+
+	srv := getGRPCServer(serveOpt, newTelemetry(serveOpt.TracerProvider, serveOpt.MeterProvider))
+	if err := opt.Plugin.Server(srv); err != nil {
+		return nil, fmt.Errorf("could not register in-process plugin %s: %v", opt.Name, err)
+	}
+	go srv.Serve(lis)
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+
+	conn, err := grpc.Dial("bufconn", append(getDialOptions(opt, t), grpc.WithContextDialer(dialer))...)
+	if err != nil {
+		srv.Stop()
+		return nil, fmt.Errorf("could not connect to in-process plugin %s: %v", opt.Name, err)
+	}
+
+	c, err := opt.Plugin.Client(conn)
+	if err != nil {
+		conn.Close()
+		srv.Stop()
+		return nil, err
+	}
+
+	return &instance{opt: opt, client: c, conn: conn, server: srv}, nil
+}
@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HEALTH_CHECK_TIMEOUT is how long a reloaded plugin has to become healthy if the context has no deadline
+const HEALTH_CHECK_TIMEOUT = 10 * time.Second
+
+// DEFAULT_HANDSHAKE_TIMEOUT is how long a plugin has to print its handshake unless PluginLoadOptions.HandshakeTimeout is set
+const DEFAULT_HANDSHAKE_TIMEOUT = 30 * time.Second
+
+// instance is a loaded plugin along with the connection and process backing it
+type instance struct {
+	opt    PluginLoadOptions
+	cs     store.CatalogStore
+	client interface{}
+	conn   *grpc.ClientConn
+
+	// server is only set for plugins served in process
+	server *grpc.Server
+
+	// proc is only set for plugins started as a subprocess.
+	// mu serializes reloads, which replace proc, with close. Once closed is set, reloads fail.
+	mu     sync.Mutex
+	proc   atomic.Pointer[process]
+	closed atomic.Bool
+}
+
+// close closes the connection to the plugin and stops its process, if any.
+// A reload in progress gives up once its process started, and close stops the process that is left.
+func (i *instance) close(ctx context.Context) error {
+	i.closed.Store(true)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.conn != nil {
+		i.conn.Close()
+	}
+
+	if i.server != nil {
+		stopServer(ctx, i.server)
+	}
+
+	if p := i.proc.Load(); p != nil {
+		return p.stop(ctx)
+	}
+	return nil
+}
+
+// stopServer stops srv gracefully, or immediately once ctx is done
+func stopServer(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+		<-done
+	}
+}
+
+// reload starts a new process for the plugin and switches the connection over to it once it is healthy.
+// The old process is then stopped gracefully, so in flight calls finish on it while new calls reconnect
+// to the new process. If the new process fails its handshake or health check it is stopped and the
+// old one keeps serving. Reloads fail once the plugin is shut down.
+func (i *instance) reload(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed.Load() {
+		return fmt.Errorf("plugin %s was shut down", i.opt.Name)
+	}
+
+	old := i.proc.Load()
+	if old == nil {
+		return fmt.Errorf("plugin %s is not running as a process", i.opt.Name)
+	}
+
+	proc, err := startProcess(i.opt)
+	if err != nil {
+		return err
+	}
+
+	if err := proc.waitHealthy(ctx); err != nil {
+		proc.stop(ctx)
+		return fmt.Errorf("plugin %s is not healthy: %v", i.opt.Name, err)
+	}
+
+	// The plugin was shut down while the new process started, nothing would stop it after the swap
+	if i.closed.Load() {
+		proc.stop(ctx)
+		return fmt.Errorf("plugin %s was shut down", i.opt.Name)
+	}
+
+	if err := proc.register(i.opt.Name, i.cs); err != nil {
+		proc.stop(ctx)
+		return err
+	}
+
+	i.proc.Store(proc)
+	i.conn.ResetConnectBackoff()
+
+	return old.stop(ctx)
+}
+
+// process is a running plugin subprocess
+type process struct {
+	cmd  *exec.Cmd
+	resp PluginResponse
+
+	// binary is the state of the executable when the process was launched
+	binary os.FileInfo
+
+	// sandbox is nil if the process is not sandboxed
+	sandbox *sandbox
+
+	// socketDir is the directory created for the unix socket of the process, if any
+	socketDir string
+
+	// execDir is the directory holding the verified copy of the executable, if any
+	execDir string
+}
+
+// handshake reads the address the plugin is listening on from its stdout.
+// It gives up after the handshake timeout, the caller then kills the process, which unblocks the read.
+func (p *process) handshake(stdout io.Reader, opt PluginLoadOptions) error {
+	timeout := opt.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DEFAULT_HANDSHAKE_TIMEOUT
+	}
+
+	line := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Scan()
+		line <- scanner.Text()
+	}()
+
+	var resp string
+	select {
+	case resp = <-line:
+	case <-time.After(timeout):
+		return fmt.Errorf("plugin %s did not complete its handshake within %s", opt.Path, timeout)
+	}
+
+	if resp != "" {
+		if err := json.Unmarshal([]byte(resp), &p.resp); err != nil {
+			return fmt.Errorf("error parsing plugin response: %v", err)
+		}
+	}
+
+	if p.resp.Address == "" {
+		return fmt.Errorf("plugin did not provide a valid address")
+	}
+
+	if p.resp.SocketType == "" {
+		p.resp.SocketType = SOCKET_TYPE_UNIX
+	}
+
+	return checkVersion(p.resp.Version, opt.VersionConstraint)
+}
+
+// register adds the address of the process to the catalog store
+func (p *process) register(name string, cs store.CatalogStore) error {
+	if !cs.Add(name, store.ServiceInfo{
+		Address: p.resp.Address,
+		Socket:  store.SocketType(p.resp.SocketType),
+	}) {
+		return fmt.Errorf("could not add service %s to catalog store", name)
+	}
+	return nil
+}
+
+// waitHealthy waits until the plugin reports it is serving through the gRPC health service.
+// Plugins that do not implement the health service are considered healthy once they accept a connection.
+func (p *process) waitHealthy(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, HEALTH_CHECK_TIMEOUT)
+		defer cancel()
+	}
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, p.resp.SocketType, p.resp.Address)
+	}
+
+	conn, err := grpc.Dial("", grpc.WithInsecure(), grpc.WithContextDialer(dialer))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("plugin reported status %s", resp.Status)
+	}
+	return nil
+}
+
+// stop asks the process to exit with SIGTERM and kills it if it is still running when ctx is done
+func (p *process) stop(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.cmd.Wait()
+	}()
+
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		p.cmd.Process.Kill()
+	}
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		p.cmd.Process.Kill()
+		<-done
+		err = fmt.Errorf("plugin %s did not exit in time and was killed: %v", p.cmd.Path, ctx.Err())
+	}
+
+	p.cleanup()
+	return err
+}
+
+// kill stops the process immediately
+func (p *process) kill() {
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cleanup()
+}
+
+// cleanup removes the unix socket of the process, which it does not get a chance to do if it is killed,
+// along with its socket directory, verified executable and sandbox
+func (p *process) cleanup() {
+	if p.resp.SocketType == SOCKET_TYPE_UNIX && p.resp.Address != "" {
+		os.Remove(p.resp.Address)
+	}
+	if p.socketDir != "" {
+		os.Remove(p.socketDir)
+	}
+	removeExecDir(p.execDir)
+	p.sandbox.cleanup()
+}
+
+// removeExecDir removes the directory of a verified copy of a plugin executable, if any
+func removeExecDir(dir string) {
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
+}
+
+// binaryChanged reports the current state of the executable and whether it was replaced since the process started
+func (p *process) binaryChanged(path string) (os.FileInfo, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		// The binary is being replaced or was removed, wait for it to reappear
+		return nil, false
+	}
+
+	return fi, !sameFileState(fi, p.binary)
+}
@@ -0,0 +1,349 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest lists the plugins loaded by a Manager
+type Manifest struct {
+	Plugins []ManifestEntry `json:"plugins" yaml:"plugins"`
+}
+
+// ManifestEntry describes a single plugin in a Manifest.
+// Relative paths are resolved against the directory of the manifest file.
+type ManifestEntry struct {
+	Name       string   `json:"name" yaml:"name"`
+	Path       string   `json:"path" yaml:"path"`
+	Args       []string `json:"args,omitempty" yaml:"args,omitempty"`
+	Env        []string `json:"env,omitempty" yaml:"env,omitempty"`
+	SocketType string   `json:"socket_type,omitempty" yaml:"socket_type,omitempty"`
+	Version    string   `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Checksum, PublicKey and Signature are used to verify the executable before it is launched
+	Checksum  string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	PublicKey string `json:"public_key,omitempty" yaml:"public_key,omitempty"`
+	Signature string `json:"signature,omitempty" yaml:"signature,omitempty"`
+}
+
+type ManagerOptions struct {
+	// Plugins maps plugin names to the Plugin used to create their clients
+	Plugins map[string]Plugin
+
+	// Default is used for plugins that are not in Plugins. If nil, those plugins fail to load.
+	Default Plugin
+
+	// LoadOptions are the base options for every plugin, e.g. to set dial options and interceptors.
+	// The name, path, args, env, socket type and version constraint are taken from the manifest.
+	// A public key set here is used for every plugin that does not have its own in the manifest.
+	// A checksum only matches a single executable, so it must be set in the manifest and loading fails if it is set here.
+	LoadOptions PluginLoadOptions
+
+	// OnReload is called after Watch reloads a plugin, with the error if the reload failed
+	OnReload func(name string, err error)
+}
+
+// Manager loads a set of plugins and tracks them by name
+type Manager struct {
+	cs  store.CatalogStore
+	opt ManagerOptions
+	t   *telemetry
+
+	mu      sync.RWMutex
+	plugins map[string]*instance
+}
+
+// NewManager returns a Manager that registers the plugins it loads in cs
+func NewManager(cs store.CatalogStore, opt ManagerOptions) *Manager {
+	return &Manager{
+		cs:      cs,
+		opt:     opt,
+		t:       newTelemetry(opt.LoadOptions.TracerProvider, opt.LoadOptions.MeterProvider),
+		plugins: make(map[string]*instance),
+	}
+}
+
+// LoadManifest reads a YAML or JSON manifest and loads the plugins listed in it
+func (m *Manager) LoadManifest(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read manifest: %v", err)
+	}
+
+	var manifest Manifest
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, &manifest)
+	default:
+		err = yaml.Unmarshal(b, &manifest)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse manifest %s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i, e := range manifest.Plugins {
+		if e.Path != "" && !filepath.IsAbs(e.Path) {
+			manifest.Plugins[i].Path = filepath.Join(dir, e.Path)
+		}
+		if e.Signature != "" && !filepath.IsAbs(e.Signature) {
+			manifest.Plugins[i].Signature = filepath.Join(dir, e.Signature)
+		}
+	}
+
+	return m.LoadAll(manifest.Plugins)
+}
+
+// LoadDir loads every executable file in dir as a plugin named after the file
+func (m *Manager) LoadDir(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read plugin directory: %v", err)
+	}
+
+	var entries []ManifestEntry
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		entries = append(entries, ManifestEntry{Name: f.Name(), Path: filepath.Join(dir, f.Name())})
+	}
+
+	return m.LoadAll(entries)
+}
+
+// LoadAll launches the plugins concurrently. Plugins that load successfully are
+// kept even if others fail, the errors of the failed plugins are joined and returned.
+func (m *Manager) LoadAll(entries []ManifestEntry) error {
+	errs := make([]error, len(entries))
+
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.Load(e)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Load launches a single plugin and tracks it under its name
+func (m *Manager) Load(e ManifestEntry) error {
+	if e.Name == "" {
+		return fmt.Errorf("plugin at %s has no name", e.Path)
+	}
+
+	m.mu.Lock()
+	_, exists := m.plugins[e.Name]
+	if !exists {
+		// Reserve the name so concurrent loads of the same plugin fail
+		m.plugins[e.Name] = nil
+	}
+	m.mu.Unlock()
+	if exists {
+		return fmt.Errorf("plugin %s is already loaded", e.Name)
+	}
+
+	opt, err := m.loadOptions(e)
+	if err == nil {
+		var inst *instance
+		inst, err = load(opt, m.cs)
+		if err == nil {
+			m.mu.Lock()
+			m.plugins[e.Name] = inst
+			m.mu.Unlock()
+			return nil
+		}
+	}
+
+	m.mu.Lock()
+	delete(m.plugins, e.Name)
+	m.mu.Unlock()
+	return fmt.Errorf("could not load plugin %s: %w", e.Name, err)
+}
+
+// Get returns the client of the named plugin
+func (m *Manager) Get(name string) (interface{}, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	inst, ok := m.plugins[name]
+	if !ok || inst == nil {
+		return nil, false
+	}
+	return inst.client, true
+}
+
+// List returns the names of the loaded plugins in sorted order
+func (m *Manager) List() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var names []string
+	for name, inst := range m.plugins {
+		if inst != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reload starts a new process for the named plugin and swaps it in once it is healthy.
+// Clients returned by Get keep working, calls are routed to the new process after the swap
+// while in flight calls finish on the old one. A failed reload leaves the old process running.
+func (m *Manager) Reload(ctx context.Context, name string) error {
+	m.mu.RLock()
+	inst, ok := m.plugins[name]
+	m.mu.RUnlock()
+	if !ok || inst == nil {
+		return fmt.Errorf("plugin %s is not loaded", name)
+	}
+
+	if err := inst.reload(ctx); err != nil {
+		return fmt.Errorf("could not reload plugin %s: %w", name, err)
+	}
+
+	m.t.restarts.Add(ctx, 1, metric.WithAttributes(attribute.String("plugin.name", name)))
+	return nil
+}
+
+// Watch checks the executables of the loaded plugins every interval and reloads a plugin when
+// its executable is replaced. A change is only acted on once the file has stopped changing
+// between two checks, so a binary that is still being copied is not launched.
+// The result of every reload is reported to ManagerOptions.OnReload. Watch blocks until ctx is done.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// pending holds binaries that changed on the last check, failed holds binaries that could not be reloaded
+	pending := make(map[string]os.FileInfo)
+	failed := make(map[string]os.FileInfo)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		m.mu.RLock()
+		plugins := make(map[string]*instance, len(m.plugins))
+		for name, inst := range m.plugins {
+			if inst != nil && inst.proc.Load() != nil {
+				plugins[name] = inst
+			}
+		}
+		m.mu.RUnlock()
+
+		for name, inst := range plugins {
+			fi, changed := inst.proc.Load().binaryChanged(inst.opt.Path)
+			if !changed || sameFileState(fi, failed[name]) {
+				continue
+			}
+
+			if !sameFileState(fi, pending[name]) {
+				pending[name] = fi
+				continue
+			}
+			delete(pending, name)
+
+			err := m.Reload(ctx, name)
+			if err != nil {
+				failed[name] = fi
+			} else {
+				delete(failed, name)
+			}
+
+			if m.opt.OnReload != nil {
+				m.opt.OnReload(name, err)
+			}
+		}
+	}
+}
+
+// ShutdownAll stops every plugin, killing the ones that are still running when ctx is done
+func (m *Manager) ShutdownAll(ctx context.Context) error {
+	m.mu.Lock()
+	plugins := m.plugins
+	m.plugins = make(map[string]*instance)
+	m.mu.Unlock()
+
+	var mu sync.Mutex
+	var errs []error
+
+	var wg sync.WaitGroup
+	for _, inst := range plugins {
+		if inst == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := inst.close(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// loadOptions builds the load options of a manifest entry on top of the base options
+func (m *Manager) loadOptions(e ManifestEntry) (PluginLoadOptions, error) {
+	opt := m.opt.LoadOptions
+	if opt.Checksum != "" {
+		return opt, fmt.Errorf("checksum of %s must be set in its manifest entry, not in the manager load options", e.Name)
+	}
+
+	opt.Name = e.Name
+	opt.Path = e.Path
+	opt.Args = e.Args
+	opt.Env = append(append([]string{}, opt.Env...), e.Env...)
+	opt.SocketType = e.SocketType
+	opt.VersionConstraint = e.Version
+	opt.Checksum = e.Checksum
+	if e.PublicKey != "" {
+		opt.PublicKey = e.PublicKey
+	}
+	opt.SignaturePath = e.Signature
+
+	p, ok := m.opt.Plugins[e.Name]
+	if !ok {
+		p = m.opt.Default
+	}
+	if p == nil {
+		return opt, fmt.Errorf("no plugin type registered for %s", e.Name)
+	}
+	opt.Plugin = p
+
+	return opt, nil
+}
+
+// sameFileState reports whether a and b describe the same file with the same contents
+func sameFileState(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
@@ -0,0 +1,143 @@
+package mdns
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ANNOUNCE_INTERVAL separates the two announcements made when advertising starts
+const ANNOUNCE_INTERVAL = time.Second
+
+// Advertiser answers mDNS queries for a service until it is closed
+type Advertiser struct {
+	conn     *conn
+	instance dnsmessage.Name
+
+	// packed responses
+	records     []byte
+	goodbye     []byte
+	enumeration []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Advertise announces the service s under name on the local network and answers queries for it
+func Advertise(name string, s store.ServiceInfo, opt Options) (*Advertiser, error) {
+	opt.setDefaults()
+
+	instance, err := instanceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Advertiser{
+		instance: instance,
+		done:     make(chan struct{}),
+	}
+
+	if a.records, err = packRecords(name, s, opt.TTL); err != nil {
+		return nil, err
+	}
+	// Records with a TTL of 0 tell browsers the service is gone
+	if a.goodbye, err = packRecords(name, s, 0); err != nil {
+		return nil, err
+	}
+	if a.enumeration, err = pack([]dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: enumerationName, Class: dnsmessage.ClassINET, TTL: uint32(opt.TTL / time.Second)},
+		Body:   &dnsmessage.PTRResource{PTR: serviceName},
+	}}); err != nil {
+		return nil, err
+	}
+
+	a.conn, err = listen(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	a.wg.Add(2)
+	go func() {
+		defer a.wg.Done()
+		a.conn.receive(a.answer)
+	}()
+	go func() {
+		defer a.wg.Done()
+		a.announce()
+	}()
+
+	return a, nil
+}
+
+// Close sends a goodbye for the service and stops answering queries
+func (a *Advertiser) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		close(a.done)
+		a.conn.write(a.goodbye)
+		err = a.conn.close()
+		a.wg.Wait()
+	})
+	return err
+}
+
+// announce sends the records unsolicited twice, as RFC 6762 asks, so running browsers find the service right away
+func (a *Advertiser) announce() {
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			select {
+			case <-a.done:
+				return
+			case <-time.After(ANNOUNCE_INTERVAL):
+			}
+		}
+		a.conn.write(a.records)
+	}
+}
+
+// answer responds to queries for plugins, for this instance, or for the service types on the network
+func (a *Advertiser) answer(msg *dnsmessage.Message) {
+	if msg.Header.Response {
+		return
+	}
+
+	for _, q := range msg.Questions {
+		switch {
+		case sameName(q.Name, serviceName) && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL),
+			sameName(q.Name, a.instance):
+			a.conn.write(a.records)
+			return
+
+		case sameName(q.Name, enumerationName) && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+			a.conn.write(a.enumeration)
+			return
+		}
+	}
+}
+
+// packRecords packs a response with the records advertising s under name
+func packRecords(name string, s store.ServiceInfo, ttl time.Duration) ([]byte, error) {
+	rrs, err := records(name, s, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pack(rrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mdns records for %s: %v", name, err)
+	}
+	return b, nil
+}
+
+// pack packs a response with answers. Packing modifies the records, so responses are packed once and reused.
+func pack(answers []dnsmessage.Resource) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: answers,
+	}
+	return msg.Pack()
+}
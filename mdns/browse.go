@@ -0,0 +1,212 @@
+package mdns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Browser is a CatalogStore of the plugins advertised on the local network.
+// Services found are kept until their TTL runs out or they send a goodbye.
+// Services added to it are only kept locally, they are not advertised, and take precedence over the ones found.
+type Browser struct {
+	conn *conn
+	opt  Options
+
+	mu      sync.Mutex
+	local   map[string]store.ServiceInfo
+	found   map[string]entry
+	changed chan struct{}
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type entry struct {
+	svc    store.ServiceInfo
+	expiry time.Time
+}
+
+// NewBrowser starts browsing for plugins, it asks for them right away and then every QueryInterval
+func NewBrowser(opt Options) (*Browser, error) {
+	opt.setDefaults()
+
+	c, err := listen(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Browser{
+		conn:    c,
+		opt:     opt,
+		local:   make(map[string]store.ServiceInfo),
+		found:   make(map[string]entry),
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	b.wg.Add(2)
+	go func() {
+		defer b.wg.Done()
+		c.receive(b.handle)
+	}()
+	go func() {
+		defer b.wg.Done()
+		b.queryLoop()
+	}()
+
+	return b, nil
+}
+
+// Close stops browsing
+func (b *Browser) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.conn.close()
+		b.wg.Wait()
+	})
+	return err
+}
+
+// Add keeps s under name locally, it is not advertised
+func (b *Browser) Add(name string, s store.ServiceInfo) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.local[name] = s
+	return true
+}
+
+// Get returns the service added locally or found on the network under name.
+// If it is unknown, the network is asked for it and Get waits up to LookupTimeout for an answer.
+func (b *Browser) Get(name string) (store.ServiceInfo, bool) {
+	s, ok, changed := b.get(name)
+	if ok || b.opt.LookupTimeout < 0 {
+		return s, ok
+	}
+
+	b.query()
+	timeout := time.NewTimer(b.opt.LookupTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return store.ServiceInfo{}, false
+		case <-b.done:
+			return store.ServiceInfo{}, false
+		}
+
+		if s, ok, changed = b.get(name); ok {
+			return s, ok
+		}
+	}
+}
+
+// get looks name up and returns a channel closed on the next change
+func (b *Browser) get(name string) (store.ServiceInfo, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.local[name]; ok {
+		return s, true, b.changed
+	}
+	if e, ok := b.found[name]; ok && time.Now().Before(e.expiry) {
+		return e.svc, true, b.changed
+	}
+	return store.ServiceInfo{}, false, b.changed
+}
+
+// List returns the services added locally and the ones found that have not expired
+func (b *Browser) List() map[string]store.ServiceInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	services := make(map[string]store.ServiceInfo, len(b.found)+len(b.local))
+	for name, e := range b.found {
+		if now.Before(e.expiry) {
+			services[name] = e.svc
+		} else {
+			delete(b.found, name)
+		}
+	}
+	for name, s := range b.local {
+		services[name] = s
+	}
+	return services
+}
+
+// Remove removes a service added locally, services found on the network are removed when they expire
+func (b *Browser) Remove(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.local[name]
+	delete(b.local, name)
+	return ok
+}
+
+// Len returns the number of services in the browser
+func (b *Browser) Len() int {
+	return len(b.List())
+}
+
+func (b *Browser) queryLoop() {
+	ticker := time.NewTicker(b.opt.QueryInterval)
+	defer ticker.Stop()
+
+	for {
+		b.query()
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// query asks the network for every plugin
+func (b *Browser) query() {
+	b.conn.send(dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: serviceName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	})
+}
+
+// handle records the plugins described by the TXT records of a response
+func (b *Browser) handle(msg *dnsmessage.Message) {
+	if !msg.Header.Response {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	updated := false
+	for _, rr := range append(msg.Answers, msg.Additionals...) {
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok || !strings.HasSuffix(strings.ToLower(rr.Header.Name.String()), "."+serviceName.String()) {
+			continue
+		}
+
+		name, svc, ok := parseTXT(txt.TXT)
+		if !ok {
+			continue
+		}
+
+		if rr.Header.TTL == 0 {
+			delete(b.found, name)
+		} else {
+			b.found[name] = entry{svc: svc, expiry: time.Now().Add(time.Duration(rr.Header.TTL) * time.Second)}
+		}
+		updated = true
+	}
+
+	if updated {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+}
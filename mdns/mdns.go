@@ -0,0 +1,264 @@
+// Package mdns discovers plugins on the local network with multicast DNS service discovery
+// (RFC 6762 and RFC 6763), as an alternative to running a catalog server.
+//
+// Plugins are advertised as SERVICE_TYPE instances. Their TXT record carries the fields of the
+// catalog Service message, so services of any socket type can be discovered, and an SRV record
+// is added for TCP services so generic DNS-SD browsers can find them too.
+package mdns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	SERVICE_TYPE = "_plugin._tcp"
+	DOMAIN       = "local."
+	MDNS_ADDRESS = "224.0.0.251:5353"
+
+	DEFAULT_TTL            = 120 * time.Second
+	DEFAULT_QUERY_INTERVAL = 60 * time.Second
+	DEFAULT_LOOKUP_TIMEOUT = time.Second
+
+	MAX_PACKET_SIZE = 9000
+)
+
+// Keys of the TXT record of a plugin
+const (
+	TXT_NAME    = "name"
+	TXT_ADDRESS = "address"
+	TXT_SOCKET  = "socket"
+	TXT_ORIGIN  = "origin"
+)
+
+// cacheFlush is set on the class of records that only one host answers for
+const cacheFlush = 1 << 15
+
+var (
+	serviceName     = dnsmessage.MustNewName(SERVICE_TYPE + "." + DOMAIN)
+	enumerationName = dnsmessage.MustNewName("_services._dns-sd._udp." + DOMAIN)
+)
+
+// Options configures advertisers and browsers
+type Options struct {
+	// Interface is the network interface multicast packets are sent and received on,
+	// the system default if nil. Tests can use the loopback interface.
+	Interface *net.Interface
+
+	// Address is the multicast group and port, defaults to MDNS_ADDRESS
+	Address string
+
+	// TTL is how long browsers keep an advertised service, defaults to DEFAULT_TTL
+	TTL time.Duration
+
+	// QueryInterval is how often a browser asks for plugins, defaults to DEFAULT_QUERY_INTERVAL
+	QueryInterval time.Duration
+
+	// LookupTimeout is how long Browser.Get waits for an unknown service to answer, defaults to DEFAULT_LOOKUP_TIMEOUT.
+	// Set it to a negative value to only return services that were already found.
+	LookupTimeout time.Duration
+}
+
+func (opt *Options) setDefaults() {
+	if opt.Address == "" {
+		opt.Address = MDNS_ADDRESS
+	}
+	if opt.TTL == 0 {
+		opt.TTL = DEFAULT_TTL
+	}
+	if opt.QueryInterval == 0 {
+		opt.QueryInterval = DEFAULT_QUERY_INTERVAL
+	}
+	if opt.LookupTimeout == 0 {
+		opt.LookupTimeout = DEFAULT_LOOKUP_TIMEOUT
+	}
+}
+
+// conn is a UDP socket joined to the mDNS multicast group
+type conn struct {
+	udp   *net.UDPConn
+	group *net.UDPAddr
+}
+
+func listen(opt Options) (*conn, error) {
+	group, err := net.ResolveUDPAddr("udp4", opt.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mdns address: %v", err)
+	}
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("mdns address %s is not a multicast address", opt.Address)
+	}
+
+	udp, err := net.ListenMulticastUDP("udp4", opt.Interface, group)
+	if err != nil {
+		return nil, fmt.Errorf("could not join mdns group: %v", err)
+	}
+
+	p := ipv4.NewPacketConn(udp)
+	if opt.Interface != nil {
+		if err := p.SetMulticastInterface(opt.Interface); err != nil {
+			udp.Close()
+			return nil, fmt.Errorf("could not set mdns interface: %v", err)
+		}
+	}
+	// Other browsers and advertisers on this host have to see our packets too
+	p.SetMulticastLoopback(true)
+	p.SetMulticastTTL(255)
+
+	return &conn{udp: udp, group: group}, nil
+}
+
+func (c *conn) send(msg dnsmessage.Message) error {
+	b, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	return c.write(b)
+}
+
+// write sends a packed message, which unlike a Message can be sent from several goroutines
+func (c *conn) write(b []byte) error {
+	_, err := c.udp.WriteToUDP(b, c.group)
+	return err
+}
+
+// receive calls fn with every DNS message received until the connection is closed, malformed packets are dropped
+func (c *conn) receive(fn func(msg *dnsmessage.Message)) {
+	buf := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, _, err := c.udp.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		fn(&msg)
+	}
+}
+
+func (c *conn) close() error {
+	return c.udp.Close()
+}
+
+// instanceName returns the DNS-SD instance name of the plugin name
+func instanceName(name string) (dnsmessage.Name, error) {
+	return dnsmessage.NewName(name + "." + serviceName.String())
+}
+
+// sameName compares DNS names, which are case insensitive
+func sameName(a, b dnsmessage.Name) bool {
+	return strings.EqualFold(a.String(), b.String())
+}
+
+// records returns the resource records advertising the service s under name
+func records(name string, s store.ServiceInfo, ttl time.Duration) ([]dnsmessage.Resource, error) {
+	instance, err := instanceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	txt := []string{TXT_NAME + "=" + name, TXT_ADDRESS + "=" + s.Address, TXT_SOCKET + "=" + string(s.Socket)}
+	if s.Origin != "" {
+		txt = append(txt, TXT_ORIGIN+"="+s.Origin)
+	}
+
+	seconds := uint32(ttl / time.Second)
+	rrs := []dnsmessage.Resource{
+		{
+			Header: dnsmessage.ResourceHeader{Name: serviceName, Class: dnsmessage.ClassINET, TTL: seconds},
+			Body:   &dnsmessage.PTRResource{PTR: instance},
+		},
+		{
+			Header: dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET | cacheFlush, TTL: seconds},
+			Body:   &dnsmessage.TXTResource{TXT: txt},
+		},
+	}
+
+	if s.Socket == store.TCP {
+		srv, err := srvRecords(instance, s.Address, seconds)
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, srv...)
+	}
+
+	return rrs, nil
+}
+
+// srvRecords returns the SRV record of a TCP service, and the address record of its target if the host is an IP
+func srvRecords(instance dnsmessage.Name, address string, ttl uint32) ([]dnsmessage.Resource, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", address, err)
+	}
+	portNum, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in address %s: %v", address, err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		target, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+		if err != nil {
+			return nil, err
+		}
+		return []dnsmessage.Resource{srvRecord(instance, target, portNum, ttl)}, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName(strings.Split(hostname, ".")[0] + "." + DOMAIN)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl}}
+	if ip4 := ip.To4(); ip4 != nil {
+		addr.Body = &dnsmessage.AResource{A: [4]byte(ip4)}
+	} else {
+		addr.Body = &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}
+	}
+
+	return []dnsmessage.Resource{srvRecord(instance, target, portNum, ttl), addr}, nil
+}
+
+func srvRecord(instance, target dnsmessage.Name, port int, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+		Body:   &dnsmessage.SRVResource{Target: target, Port: uint16(port)},
+	}
+}
+
+// parseTXT returns the service described by the TXT record of a plugin, and false if it is not one
+func parseTXT(txt []string) (string, store.ServiceInfo, bool) {
+	fields := make(map[string]string, len(txt))
+	for _, kv := range txt {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			fields[k] = v
+		}
+	}
+
+	name, address := fields[TXT_NAME], fields[TXT_ADDRESS]
+	socket := store.SocketType(fields[TXT_SOCKET])
+	if name == "" || address == "" || (socket != store.TCP && socket != store.UNIX) {
+		return "", store.ServiceInfo{}, false
+	}
+
+	return name, store.ServiceInfo{Address: address, Socket: socket, Origin: fields[TXT_ORIGIN]}, true
+}
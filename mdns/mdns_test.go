@@ -0,0 +1,153 @@
+package mdns
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin/store"
+)
+
+// loopbackOptions returns options sending multicast on the loopback interface, on a free port so
+// tests neither see nor disturb the mDNS traffic of the host
+func loopbackOptions(t *testing.T) Options {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lo *net.Interface
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			lo = &ifaces[i]
+			break
+		}
+	}
+	if lo == nil {
+		t.Skip("no loopback interface")
+	}
+
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	udp.Close()
+
+	return Options{Interface: lo, Address: net.JoinHostPort("224.0.0.251", strconv.Itoa(port))}
+}
+
+func newBrowser(t *testing.T, opt Options) *Browser {
+	t.Helper()
+
+	b, err := NewBrowser(opt)
+	if err != nil {
+		t.Skipf("multicast is not available on loopback: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// waitFor polls cond until it holds or the timeout runs out
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestAdvertiseAndBrowse(t *testing.T) {
+	opt := loopbackOptions(t)
+	b := newBrowser(t, opt)
+
+	hello := store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP, Origin: "host-a"}
+	a, err := Advertise("hello", hello, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	if !waitFor(5*time.Second, func() bool { _, ok := b.Get("hello"); return ok }) {
+		t.Skip("multicast packets are not delivered on loopback")
+	}
+	if s, _ := b.Get("hello"); s != hello {
+		t.Fatalf("expected %v, got %v", hello, s)
+	}
+
+	// A browser started later asks for the service and gets an answer
+	late := newBrowser(t, opt)
+	if s, ok := late.Get("hello"); !ok || s != hello {
+		t.Fatalf("expected the lookup to be answered with %v, got %v", hello, s)
+	}
+
+	unix := store.ServiceInfo{Address: "/run/plugins/world.sock", Socket: store.UNIX}
+	world, err := Advertise("world", unix, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer world.Close()
+	if s, ok := b.Get("world"); !ok || s != unix {
+		t.Fatalf("expected %v, got %v", unix, s)
+	}
+
+	if _, ok := b.Get("missing"); ok {
+		t.Fatal("expected missing not to be found")
+	}
+}
+
+func TestBrowseGoodbye(t *testing.T) {
+	opt := loopbackOptions(t)
+	b := newBrowser(t, opt)
+
+	a, err := Advertise("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(5*time.Second, func() bool { _, ok := b.Get("hello"); return ok }) {
+		a.Close()
+		t.Skip("multicast packets are not delivered on loopback")
+	}
+
+	a.Close()
+	if !waitFor(5*time.Second, func() bool { return b.Len() == 0 }) {
+		t.Fatal("expected the goodbye to remove hello")
+	}
+}
+
+func TestBrowseTTLExpiry(t *testing.T) {
+	opt := loopbackOptions(t)
+	opt.LookupTimeout = -1
+	b := newBrowser(t, opt)
+
+	// A host that went away without a goodbye, its records are only announced once
+	c, err := listen(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	records, err := packRecords("hello", store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitFor(5*time.Second, func() bool {
+		c.write(records)
+		_, ok := b.Get("hello")
+		return ok
+	}) {
+		t.Skip("multicast packets are not delivered on loopback")
+	}
+
+	if !waitFor(3*time.Second, func() bool { _, ok := b.Get("hello"); return !ok }) {
+		t.Fatal("expected hello to expire after its TTL")
+	}
+	if n := b.Len(); n != 0 {
+		t.Fatalf("expected no service once hello expired, got %d", n)
+	}
+}
@@ -1,20 +1,29 @@
 package plugin
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/cvhariharan/plugin/catalog/protogen"
+	"github.com/Masterminds/semver/v3"
+	"github.com/cvhariharan/plugin/mdns"
 	"github.com/cvhariharan/plugin/store"
 	"github.com/lithammer/shortuuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -23,9 +32,20 @@ const (
 	PLUGIN_SOCKET_TYPE       = "PLUGIN_SOCKET_TYPE"
 	PLUGIN_MIN_PORT          = "PLUGIN_MIN_PORT"
 	PLUGIN_MAX_PORT          = "PLUGIN_MAX_PORT"
+	PLUGIN_SOCKET_DIR        = "PLUGIN_SOCKET_DIR"
+	PLUGIN_PEER_UID          = "PLUGIN_PEER_UID"
+	PLUGIN_PEER_PID          = "PLUGIN_PEER_PID"
+	PLUGIN_CATALOG_TOKEN     = "PLUGIN_CATALOG_TOKEN"
+	PLUGIN_CATALOG_IDENTITY  = "PLUGIN_CATALOG_IDENTITY"
+	PLUGIN_CATALOG_KEY       = "PLUGIN_CATALOG_KEY"
+	PLUGIN_REGISTER_INTERVAL = "PLUGIN_REGISTER_INTERVAL"
+	PLUGIN_MDNS              = "PLUGIN_MDNS"
 	MIN_PORT                 = 10000
 	MAX_PORT                 = 15000
 
+	// DEFAULT_STOP_TIMEOUT is how long a plugin waits for in flight calls when it is stopped
+	DEFAULT_STOP_TIMEOUT = 10 * time.Second
+
 	SOCKET_TYPE_TCP  = "tcp"
 	SOCKET_TYPE_UNIX = "unix"
 )
@@ -40,98 +60,380 @@ type PluginLoadOptions struct {
 	Path    string
 	Address string
 	Plugin  Plugin
+
+	// InProcess serves Plugin in the host process over an in-memory connection instead of launching Path.
+	// Plugin must then have its implementation set, and is configured by ServeOptions.
+	InProcess    bool
+	ServeOptions PluginServeOptions
+
+	// Args and Env are passed to the plugin process in addition to the plugin environment variables
+	Args []string
+	Env  []string
+
+	// SocketType is the socket the plugin process listens on, SOCKET_TYPE_UNIX by default
+	SocketType string
+
+	// SocketDir is the directory the plugin creates its unix socket in.
+	// Defaults to a private temporary directory that is removed when the plugin stops.
+	SocketDir string
+
+	// VerifyPeer makes the plugin reject connections to its unix socket from any process but the host,
+	// using SO_PEERCRED. Only supported on linux.
+	VerifyPeer bool
+
+	// VersionConstraint is a semver constraint, like ">= 1.2, < 2", the version reported by the plugin must satisfy
+	VersionConstraint string
+
+	// HandshakeTimeout is how long the plugin process has to print its handshake before it is killed.
+	// Defaults to DEFAULT_HANDSHAKE_TIMEOUT.
+	HandshakeTimeout time.Duration
+
+	// Checksum is the expected hex encoded SHA-256 of the plugin executable
+	Checksum string
+
+	// PublicKey is a base64 encoded ed25519 public key or a minisign public key.
+	// If set, the executable must have a valid detached signature made with the matching private key.
+	PublicKey string
+
+	// SignaturePath is the path of the detached signature.
+	// Defaults to Path with a .minisig extension for minisign keys and a .sig extension otherwise.
+	SignaturePath string
+
+	// ExecDir is where a verified plugin is copied to and executed from, so the process runs the contents
+	// that were verified even if Path is replaced meanwhile. Defaults to the system temporary directory.
+	ExecDir string
+
+	// Sandbox restricts the privileges and resources of the plugin process, only supported on linux
+	Sandbox *SandboxOptions
+
+	// MetadataKeys are the incoming metadata keys forwarded on calls to the plugin.
+	// Defaults to DefaultMetadataKeys if nil.
+	MetadataKeys []string
+
+	// PerRPCCredentials are sent on every call to the plugin, e.g. TokenCredentials
+	PerRPCCredentials credentials.PerRPCCredentials
+
+	// DialOptions are appended to the default dial options, so they can override them
+	DialOptions []grpc.DialOption
+
+	// UnaryInterceptors and StreamInterceptors are chained after the default client interceptors
+	UnaryInterceptors  []grpc.UnaryClientInterceptor
+	StreamInterceptors []grpc.StreamClientInterceptor
+
+	// TracerProvider and MeterProvider are used to instrument the plugin.
+	// Defaults to the global OpenTelemetry providers if nil.
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
 }
 
 type PluginServeOptions struct {
 	Name string
 	Host string
+
+	// Version of the plugin reported to the host, used to check version constraints
+	Version string
+
+	// MetadataKeys are the incoming metadata keys forwarded on calls made by the plugin with a handler context.
+	// Defaults to DefaultMetadataKeys if nil.
+	MetadataKeys []string
+
+	// StopTimeout is how long in flight calls have to finish once the plugin is asked to stop,
+	// after which the server is stopped forcibly. Defaults to DEFAULT_STOP_TIMEOUT.
+	StopTimeout time.Duration
+
+	// ServerOptions are appended to the default server options, so they can override them
+	ServerOptions []grpc.ServerOption
+
+	// UnaryInterceptors and StreamInterceptors are chained after the default server interceptors
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+
+	// Tokens, if set, are the bearer tokens accepted on calls to the plugin. Health checks do not need a token.
+	Tokens []string
+
+	// CatalogDialOptions are appended to the dial options used to register with the discovery server
+	CatalogDialOptions []grpc.DialOption
+
+	// CatalogToken is the bearer token used to register with the discovery server,
+	// or CatalogIdentity and CatalogKey to sign the registration with HMAC.
+	// Default to the PLUGIN_CATALOG_TOKEN, PLUGIN_CATALOG_IDENTITY and PLUGIN_CATALOG_KEY environment variables.
+	CatalogToken    string
+	CatalogIdentity string
+	CatalogKey      []byte
+
+	// RegisterInterval is how often the plugin registers again with the discovery server, which renews its lease.
+	// Defaults to the PLUGIN_REGISTER_INTERVAL environment variable, or DEFAULT_REGISTER_INTERVAL.
+	RegisterInterval time.Duration
+
+	// OnRegister, if set, is called with the result of every registration attempt, nil on success
+	OnRegister func(err error)
+
+	// MDNS, if set, advertises the plugin on the local network with mDNS/DNS-SD, see mdns.Browser.
+	// Setting PLUGIN_MDNS to true advertises it with the default options.
+	MDNS *mdns.Options
+
+	// TracerProvider and MeterProvider are used to instrument the plugin.
+	// Defaults to the global OpenTelemetry providers if nil.
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
 }
 
 type PluginResponse struct {
 	SocketType string `json:"socket_type"`
 	Address    string `json:"address"`
+	Version    string `json:"version,omitempty"`
 }
 
-// Load loads a plugin either from a remote address or a local process.
+// Load loads a plugin either from a remote address, in process or from a local process.
 // If the address is provided, it connects to the remote plugin using gRPC.
+// If InProcess is set, it serves the plugin in the host process over an in-memory connection.
 // If not, it starts the plugin in a subprocess and returns the client.
 func Load(opt PluginLoadOptions, cs store.CatalogStore) (interface{}, error) {
+	inst, err := load(opt, cs)
+	if err != nil {
+		return nil, err
+	}
+
+	return inst.client, nil
+}
+
+// load loads a plugin and returns the instance backing it
+func load(opt PluginLoadOptions, cs store.CatalogStore) (*instance, error) {
+	t := newTelemetry(opt.TracerProvider, opt.MeterProvider)
+	ctx, span := t.startSpan(context.Background(), "plugin.Load", attribute.String("plugin.name", opt.Name))
+
+	var inst *instance
+	var err error
 	if opt.Address != "" {
-		return loadRemote(opt)
+		span.SetAttributes(attribute.String("plugin.address", opt.Address))
+		inst, err = loadRemote(opt, t)
+	} else if opt.InProcess {
+		span.SetAttributes(attribute.Bool("plugin.in_process", true))
+		inst, err = loadInProcess(opt, t)
+	} else {
+		span.SetAttributes(attribute.String("plugin.path", opt.Path))
+		inst, err = loadProcess(ctx, opt, cs, t)
+	}
+
+	if err != nil {
+		t.loadErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("plugin.name", opt.Name)))
 	}
+	endSpan(span, err)
 
-	return loadProcess(opt, cs)
+	return inst, err
 }
 
 // loadRemote connects to a remote plugin using gRPC and returns the client
-func loadRemote(opt PluginLoadOptions) (interface{}, error) {
-	conn, err := grpc.Dial(opt.Address, grpc.WithInsecure())
+func loadRemote(opt PluginLoadOptions, t *telemetry) (*instance, error) {
+	conn, err := grpc.Dial(opt.Address, getDialOptions(opt, t)...)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to remote plugin: %v", err)
 	}
 
-	return opt.Plugin.Client(conn)
+	c, err := opt.Plugin.Client(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &instance{opt: opt, client: c, conn: conn}, nil
 }
 
-// loadProcess starts the plugin in a subprocess and returns the client
-func loadProcess(opt PluginLoadOptions, cs store.CatalogStore) (interface{}, error) {
-	cmd := exec.Command(opt.Path)
+// loadProcess starts the plugin in a subprocess and returns the client.
+// The connection dials whichever process currently backs the instance, so the process can be replaced by reload.
+func loadProcess(ctx context.Context, opt PluginLoadOptions, cs store.CatalogStore, t *telemetry) (*instance, error) {
+	start := time.Now()
+	_, startSpan := t.startSpan(ctx, "plugin.start")
+	proc, err := startProcess(opt)
+	endSpan(startSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &instance{opt: opt, cs: cs}
+	inst.proc.Store(proc)
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		p := inst.proc.Load()
+		return net.Dial(p.resp.SocketType, p.resp.Address)
+	}
+
+	opts := append(getDialOptions(opt, t),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(dialer),
+	)
+
+	_, dialSpan := t.startSpan(ctx, "plugin.dial", attribute.String("plugin.address", proc.resp.Address))
+	conn, err := grpc.Dial("", opts...)
+	endSpan(dialSpan, err)
+	if err != nil {
+		proc.stop(ctx)
+		return nil, fmt.Errorf("could not create grpc client conn to %s: %v", proc.resp.Address, err)
+	}
+	inst.conn = conn
+	t.startTime.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("plugin.name", opt.Name)))
+
+	_, addSpan := t.startSpan(ctx, "catalog.add")
+	err = proc.register(opt.Name, cs)
+	endSpan(addSpan, err)
+	if err != nil {
+		inst.close(ctx)
+		return nil, err
+	}
+
+	inst.client, err = opt.Plugin.Client(conn)
+	if err != nil {
+		inst.close(ctx)
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+// startProcess launches the plugin and reads the address it is listening on from its stdout.
+// The process is stopped if the handshake fails or its version does not satisfy the constraint.
+func startProcess(opt PluginLoadOptions) (*process, error) {
+	socketType := opt.SocketType
+	if socketType == "" {
+		socketType = SOCKET_TYPE_UNIX
+	}
+
+	// Stat the binary before launching it, so a replacement made during the launch is detected later
+	binary, err := os.Stat(opt.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not launch plugin %s: %v", opt.Path, err)
+	}
+
+	data, err := verifyBinary(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// A verified plugin is executed from a private copy of the contents that were verified
+	path, execDir := opt.Path, ""
+	if data != nil {
+		execDir, path, err = copyVerifiedBinary(opt, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cmd := exec.Command(path, opt.Args...)
+	cmd.Args[0] = opt.Path
 	var env []string
-	env = append(env, fmt.Sprintf("%s=unix", PLUGIN_SOCKET_TYPE))
+	env = append(env, fmt.Sprintf("%s=%s", PLUGIN_SOCKET_TYPE, socketType))
 	env = append(env, fmt.Sprintf("%s=%d", PLUGIN_MIN_PORT, MIN_PORT))
 	env = append(env, fmt.Sprintf("%s=%d", PLUGIN_MAX_PORT, MAX_PORT))
+
+	sb, err := newSandbox(opt.Name, opt.Sandbox)
+	if err != nil {
+		removeExecDir(execDir)
+		return nil, fmt.Errorf("could not sandbox plugin %s: %v", opt.Path, err)
+	}
+
+	var socketDir string
+	if socketType == SOCKET_TYPE_UNIX {
+		dir, owned, err := createSocketDir(opt)
+		if err != nil {
+			sb.cleanup()
+			removeExecDir(execDir)
+			return nil, err
+		}
+		if owned {
+			socketDir = dir
+		}
+		env = append(env, fmt.Sprintf("%s=%s", PLUGIN_SOCKET_DIR, dir))
+
+		if opt.VerifyPeer {
+			uid, pid := sb.peerIDs(os.Getuid(), os.Getpid())
+			env = append(env, fmt.Sprintf("%s=%d", PLUGIN_PEER_UID, uid))
+			env = append(env, fmt.Sprintf("%s=%d", PLUGIN_PEER_PID, pid))
+		}
+	}
+
 	cmd.Env = append(cmd.Env, env...)
+	cmd.Env = append(cmd.Env, opt.Env...)
+	sb.prepare(cmd)
+
+	proc := &process{cmd: cmd, binary: binary, sandbox: sb, socketDir: socketDir, execDir: execDir}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		proc.cleanup()
 		return nil, fmt.Errorf("error creating stdout pipe: %v", err)
 	}
 
 	if err := cmd.Start(); err != nil {
+		proc.cleanup()
 		return nil, fmt.Errorf("could not launch plugin %s: %v", opt.Path, err)
 	}
 
-	var pluginResp PluginResponse
-	scanner := bufio.NewScanner(stdout)
-	if scanner.Scan() {
-		resp := scanner.Text()
-		if err := json.Unmarshal([]byte(resp), &pluginResp); err != nil {
-			return nil, fmt.Errorf("error parsing plugin response: %v", err)
-		}
+	if err := sb.started(cmd.Process.Pid); err != nil {
+		proc.kill()
+		return nil, err
 	}
 
-	if pluginResp.Address == "" {
-		return nil, fmt.Errorf("plugin did not provide a valid address")
+	err = proc.handshake(stdout, opt)
+	if err != nil {
+		proc.kill()
+		return nil, err
 	}
 
-	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
-		return net.Dial("unix", pluginResp.Address)
-	}
+	return proc, nil
+}
 
-	opts := []grpc.DialOption{
-		grpc.WithInsecure(),
-		grpc.WithBlock(),
-		grpc.WithContextDialer(dialer),
+// checkVersion checks that the version reported by a plugin satisfies constraint.
+// An empty constraint accepts any version.
+func checkVersion(version, constraint string) error {
+	if constraint == "" {
+		return nil
 	}
 
-	conn, err := grpc.Dial("", opts...)
+	c, err := semver.NewConstraint(constraint)
 	if err != nil {
-		return nil, fmt.Errorf("could not create grpc client conn to %s: %v", pluginResp.Address, err)
+		return fmt.Errorf("invalid version constraint %q: %v", constraint, err)
 	}
 
-	if !cs.Add(opt.Name, store.ServiceInfo{
-		Address: pluginResp.Address,
-		Socket:  SOCKET_TYPE_UNIX,
-	}) {
-		return nil, fmt.Errorf("could not add service %s to catalog store", opt.Name)
+	if version == "" {
+		return fmt.Errorf("plugin did not report a version, required %s", constraint)
+	}
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid plugin version %q: %v", version, err)
 	}
 
-	return opt.Plugin.Client(conn)
+	if !c.Check(v) {
+		return fmt.Errorf("plugin version %s does not satisfy %s", version, constraint)
+	}
+	return nil
 }
 
 // Serve starts a gRPC server for the plugin and listens on the provided address.
 // If host is empty, it binds to all interfaces but returns the first non-loopback local IP address for the client and discovery server.
+// The server stops when the plugin receives SIGTERM or an interrupt, which is how the host stops it.
+// Plugins that handle signals themselves should use ServeContext.
 func Serve(p Plugin, opt PluginServeOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	return ServeContext(ctx, p, opt)
+}
+
+// ServeContext is like Serve but stops the server when ctx is done instead of on signals.
+// In flight calls are given StopTimeout to finish before the server is stopped forcibly.
+// It returns nil once the server has stopped.
+func ServeContext(ctx context.Context, p Plugin, opt PluginServeOptions) (err error) {
+	t := newTelemetry(opt.TracerProvider, opt.MeterProvider)
+
+	// The span only covers the plugin startup and registration, not the lifetime of the server
+	_, span := t.startSpan(ctx, "plugin.Serve", attribute.String("plugin.name", opt.Name))
+	var started bool
+	defer func() {
+		if !started {
+			endSpan(span, err)
+		}
+	}()
+
 	socketType := os.Getenv(PLUGIN_SOCKET_TYPE)
 	if socketType == "" {
 		socketType = SOCKET_TYPE_TCP
@@ -139,14 +441,12 @@ func Serve(p Plugin, opt PluginServeOptions) error {
 
 	var resp PluginResponse
 	resp.SocketType = socketType
+	resp.Version = opt.Version
 
 	var lis net.Listener
-	var err error
-	var reqSocket protogen.SocketType
 
 	switch socketType {
 	case SOCKET_TYPE_TCP:
-		reqSocket = protogen.SocketType_TCP
 		min := MIN_PORT
 		max := MAX_PORT
 
@@ -187,43 +487,92 @@ func Serve(p Plugin, opt PluginServeOptions) error {
 		}
 
 	case SOCKET_TYPE_UNIX:
-		reqSocket = protogen.SocketType_UNIX
-		lis, err = getUnixSocket()
+		lis, err = getUnixSocket(os.Getenv(PLUGIN_SOCKET_DIR))
 		if err != nil {
 			return err
 		}
 		defer lis.Close()
+
+		// The host asks for peer checks by passing the IDs its connections come from
+		if uid := os.Getenv(PLUGIN_PEER_UID); uid != "" {
+			lis, err = newPeerListener(lis, uid, os.Getenv(PLUGIN_PEER_PID))
+			if err != nil {
+				return err
+			}
+		}
 		resp.Address = lis.Addr().String()
+
+	default:
+		return fmt.Errorf("unsupported socket type %s", socketType)
 	}
 
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
 		return fmt.Errorf("error encoding plugin response: %v", err)
 	}
 
-	// If PLUGIN_DISCOVERY_ADDRESS is set, register the plugin to the discovery server
-	if len(os.Getenv(PLUGIN_DISCOVERY_ADDRESS)) != 0 {
-		discoveryAddress := os.Getenv(PLUGIN_DISCOVERY_ADDRESS)
-		listener, err := grpc.Dial(discoveryAddress, grpc.WithInsecure())
+	srv := getGRPCServer(opt, t)
+	p.Server(srv)
+
+	// If PLUGIN_DISCOVERY_ADDRESS is set, keep the plugin registered to each of the comma separated
+	// discovery servers in the background. The plugin is served even while they are unreachable.
+	regCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, address := range discoveryAddresses() {
+		reg, err := newRegistration(opt, t, address,
+			store.ServiceInfo{Address: resp.Address, Socket: store.SocketType(socketType)})
 		if err != nil {
-			return fmt.Errorf("could not connect to discovery server: %v", err)
+			return err
 		}
-		defer listener.Close()
+		defer reg.client.Close()
 
-		client := protogen.NewCatalogClient(listener)
-		req := &protogen.Service{
-			Name:       opt.Name,
-			Address:    resp.Address,
-			SocketType: reqSocket,
-		}
+		go reg.run(regCtx)
+	}
 
-		if _, err := client.Add(context.Background(), req); err != nil {
-			return fmt.Errorf("could not register plugin to discovery server: %v", err)
+	if mdnsOpt, err := advertiseOptions(opt); err != nil {
+		return err
+	} else if mdnsOpt != nil {
+		adv, err := mdns.Advertise(opt.Name, store.ServiceInfo{Address: resp.Address, Socket: store.SocketType(socketType)}, *mdnsOpt)
+		if err != nil {
+			return fmt.Errorf("could not advertise plugin: %v", err)
 		}
+		defer adv.Close()
 	}
 
-	srv := getGRPCServer()
-	p.Server(srv)
-	return srv.Serve(lis)
+	started = true
+	span.SetAttributes(attribute.String("plugin.address", resp.Address))
+	endSpan(span, nil)
+
+	// Drain in flight calls when the plugin is stopped, e.g. when it is replaced during a reload
+	stopTimeout := opt.StopTimeout
+	if stopTimeout == 0 {
+		stopTimeout = DEFAULT_STOP_TIMEOUT
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	graceful := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(graceful)
+	}()
+
+	// Stop closes the connections without waiting for the handlers that are still running.
+	// It can block behind GracefulStop, which holds the server while it waits for them, so it is not waited for.
+	select {
+	case <-graceful:
+	case <-time.After(stopTimeout):
+		go srv.Stop()
+	}
+	return nil
 }
 
 // getTCPPort interates over the port range and finds an unused TCP port
@@ -241,26 +590,116 @@ func getTCPPort(min, max int) (net.Listener, error) {
 	return nil, fmt.Errorf("no ports available")
 }
 
-func getUnixSocket() (net.Listener, error) {
-	socketName := filepath.Join("/tmp", shortuuid.New())
+// getUnixSocket listens on a unix socket in dir that only the owner can connect to.
+// If dir is empty, a private temporary directory is created and removed when the listener is closed.
+func getUnixSocket(dir string) (net.Listener, error) {
+	var owned bool
+	if dir == "" {
+		var err error
+		dir, err = os.MkdirTemp("", "plugin-")
+		if err != nil {
+			return nil, fmt.Errorf("could not create socket directory: %v", err)
+		}
+		owned = true
+	}
+
+	socketName := filepath.Join(dir, shortuuid.New())
 
 	lis, err := net.Listen("unix", socketName)
 	if err != nil {
+		if owned {
+			os.Remove(dir)
+		}
 		return nil, fmt.Errorf("could not listen on unix socket %s: %v", socketName, err)
 	}
 
-	return lis, err
+	if err := os.Chmod(socketName, 0600); err != nil {
+		lis.Close()
+		if owned {
+			os.Remove(dir)
+		}
+		return nil, fmt.Errorf("could not set permissions on unix socket %s: %v", socketName, err)
+	}
+
+	if owned {
+		return &dirListener{Listener: lis, dir: dir}, nil
+	}
+	return lis, nil
+}
+
+// getDialOptions returns the dial options used to connect to a plugin,
+// which are the defaults followed by the options and interceptors set in opt
+func getDialOptions(opt PluginLoadOptions, t *telemetry) []grpc.DialOption {
+	dialOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(t.clientHandler()),
+		grpc.WithChainUnaryInterceptor(
+			propagateUnaryClientInterceptor(opt.MetadataKeys),
+			errorUnaryClientInterceptor,
+		),
+		grpc.WithChainStreamInterceptor(
+			propagateStreamClientInterceptor(opt.MetadataKeys),
+			errorStreamClientInterceptor,
+		),
+	}
+
+	if len(opt.UnaryInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(opt.UnaryInterceptors...))
+	}
+	if len(opt.StreamInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(opt.StreamInterceptors...))
+	}
+	if opt.PerRPCCredentials != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(opt.PerRPCCredentials))
+	}
+
+	return append(dialOpts, opt.DialOptions...)
 }
 
 // getGRPCServer returns a server with default values
-func getGRPCServer() *grpc.Server {
-	var opt []grpc.ServerOption
-	grpcServer := grpc.NewServer(opt...)
+// followed by the options and interceptors set in opt
+func getGRPCServer(opt PluginServeOptions, t *telemetry) *grpc.Server {
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(t.serverHandler()),
+		grpc.ChainUnaryInterceptor(
+			propagateUnaryServerInterceptor(opt.MetadataKeys),
+			errorUnaryServerInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			propagateStreamServerInterceptor(opt.MetadataKeys),
+			errorStreamServerInterceptor,
+		),
+	}
+
+	if len(opt.Tokens) > 0 {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(tokenUnaryServerInterceptor(opt.Tokens)),
+			grpc.ChainStreamInterceptor(tokenStreamServerInterceptor(opt.Tokens)),
+		)
+	}
+	if len(opt.UnaryInterceptors) > 0 {
+		serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(opt.UnaryInterceptors...))
+	}
+	if len(opt.StreamInterceptors) > 0 {
+		serverOpts = append(serverOpts, grpc.ChainStreamInterceptor(opt.StreamInterceptors...))
+	}
+	serverOpts = append(serverOpts, opt.ServerOptions...)
+
+	grpcServer := grpc.NewServer(serverOpts...)
 	reflection.Register(grpcServer)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
 
 	return grpcServer
 }
 
+// envDefault returns value, or the environment variable key if value is empty
+func envDefault(value, key string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
+
 // getLocalIP returns the local IP address of the machine.
 // It iterates over all network interfaces and returns the first non-loopback IPv4 address it finds
 func getLocalIP() (string, error) {
@@ -0,0 +1,79 @@
+package plugintest
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/store"
+)
+
+// BuildPlugin builds the main package pkg, a package path or a directory, into a temporary directory
+// removed at the end of the test and returns the path of the executable
+func BuildPlugin(t testing.TB, pkg string) string {
+	t.Helper()
+
+	name := filepath.Base(pkg)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = "plugin"
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	cmd := exec.Command("go", "build", "-o", path, pkg)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not build plugin %s: %v\n%s", pkg, err, out)
+	}
+	return path
+}
+
+// LoadPlugin launches the plugin described by opt and stops it at the end of the test
+func LoadPlugin(t testing.TB, cs store.CatalogStore, opt plugin.PluginLoadOptions) interface{} {
+	t.Helper()
+
+	// A checksum only applies to a single executable, so the manager takes it from the entry
+	entry := plugin.ManifestEntry{
+		Name:       opt.Name,
+		Path:       opt.Path,
+		Args:       opt.Args,
+		SocketType: opt.SocketType,
+		Version:    opt.VersionConstraint,
+		Checksum:   opt.Checksum,
+		Signature:  opt.SignaturePath,
+	}
+	opt.Checksum = ""
+
+	m := plugin.NewManager(cs, plugin.ManagerOptions{Default: opt.Plugin, LoadOptions: opt})
+	if err := m.Load(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		m.ShutdownAll(ctx)
+	})
+
+	c, _ := m.Get(opt.Name)
+	return c
+}
+
+// LoadInProcess serves p in the test process and returns its client, stopping it at the end of the test
+func LoadInProcess(t testing.TB, name string, p plugin.Plugin) interface{} {
+	t.Helper()
+	return LoadPlugin(t, NewFakeCatalogStore(), plugin.PluginLoadOptions{Name: name, Plugin: p, InProcess: true})
+}
+
+// writeScript writes an executable shell script to a temporary directory
+func writeScript(t testing.TB, name, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("could not write %s: %v", name, err)
+	}
+	return path
+}
@@ -0,0 +1,30 @@
+package plugintest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cvhariharan/plugin/catalog"
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc"
+)
+
+// StartCatalog serves cs as a catalog on a random local port until the end of the test and returns its address.
+// Plugins register with it when the address is passed in their PLUGIN_DISCOVERY_ADDRESS environment variable.
+func StartCatalog(t testing.TB, cs store.CatalogStore, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	srv, err := catalog.NewServer(cs, catalog.Options{Address: "127.0.0.1:0", GRPCOptions: opts})
+	if err != nil {
+		t.Fatalf("could not create catalog: %v", err)
+	}
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start catalog: %v", err)
+	}
+	t.Cleanup(func() {
+		srv.Stop(context.Background())
+	})
+
+	return srv.Addr().String()
+}
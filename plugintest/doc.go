@@ -0,0 +1,4 @@
+// Package plugintest provides helpers to test plugins and hosts: a fake catalog store,
+// an in-process catalog server, building and launching plugins from a Go package,
+// fault injection and assertions on the state of a catalog.
+package plugintest
@@ -0,0 +1,71 @@
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"google.golang.org/grpc"
+)
+
+// Environment variables read by the interceptors added by WithFaults
+const (
+	PLUGINTEST_CRASH_METHOD = "PLUGINTEST_CRASH_METHOD"
+	PLUGINTEST_CALL_DELAY   = "PLUGINTEST_CALL_DELAY"
+)
+
+// WithFaults adds the fault injection interceptors to opt. Plugins built for tests serve with it,
+// and the host turns faults on by passing CrashMidCall or DelayCalls in PluginLoadOptions.Env.
+func WithFaults(opt plugin.PluginServeOptions) plugin.PluginServeOptions {
+	opt.UnaryInterceptors = append([]grpc.UnaryServerInterceptor{faultUnaryInterceptor}, opt.UnaryInterceptors...)
+	opt.StreamInterceptors = append([]grpc.StreamServerInterceptor{faultStreamInterceptor}, opt.StreamInterceptors...)
+	return opt
+}
+
+// CrashMidCall returns the environment variable that makes a plugin served WithFaults exit
+// after it receives a call to method, given either as a full gRPC method name or just the method
+func CrashMidCall(method string) string {
+	return fmt.Sprintf("%s=%s", PLUGINTEST_CRASH_METHOD, method)
+}
+
+// DelayCalls returns the environment variable that makes a plugin served WithFaults wait d before handling each call
+func DelayCalls(d time.Duration) string {
+	return fmt.Sprintf("%s=%s", PLUGINTEST_CALL_DELAY, d)
+}
+
+// MalformedHandshake returns an executable that prints an invalid handshake line and then hangs like a broken plugin
+func MalformedHandshake(t testing.TB) string {
+	t.Helper()
+	return writeScript(t, "malformed-handshake", "echo 'this is not a handshake'\nexec sleep 3600\n")
+}
+
+// SlowHandshake returns an executable that waits delay before starting the plugin at path
+func SlowHandshake(t testing.TB, path string, delay time.Duration) string {
+	t.Helper()
+	return writeScript(t, "slow-handshake", fmt.Sprintf("sleep %f\nexec '%s' \"$@\"\n", delay.Seconds(), path))
+}
+
+// injectFault applies the faults configured in the environment to a call to method
+func injectFault(method string) {
+	if d, err := time.ParseDuration(os.Getenv(PLUGINTEST_CALL_DELAY)); err == nil {
+		time.Sleep(d)
+	}
+
+	if crash := os.Getenv(PLUGINTEST_CRASH_METHOD); crash != "" && (crash == method || crash == path.Base(method)) {
+		os.Exit(2)
+	}
+}
+
+func faultUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	injectFault(info.FullMethod)
+	return handler(ctx, req)
+}
+
+func faultStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	injectFault(info.FullMethod)
+	return handler(srv, ss)
+}
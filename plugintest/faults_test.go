@@ -0,0 +1,44 @@
+package plugintest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCrashMidCall(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	c := plugintest.LoadPlugin(t, plugintest.NewFakeCatalogStore(), plugin.PluginLoadOptions{
+		Name:   "echo",
+		Path:   path,
+		Plugin: &plugin.DynamicPlugin[any]{},
+		Env:    []string{plugintest.CrashMidCall("Call")},
+	})
+
+	_, err := c.(*plugin.DynamicClient).Call("Echo", "hello")
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the call to fail with Unavailable, got %v", err)
+	}
+}
+
+func TestDelayCalls(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	c := plugintest.LoadPlugin(t, plugintest.NewFakeCatalogStore(), plugin.PluginLoadOptions{
+		Name:   "echo",
+		Path:   path,
+		Plugin: &plugin.DynamicPlugin[any]{},
+		Env:    []string{plugintest.DelayCalls(300 * time.Millisecond)},
+	})
+
+	start := time.Now()
+	if _, err := c.(*plugin.DynamicClient).Call("Echo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("call took %s, expected it to be delayed", elapsed)
+	}
+}
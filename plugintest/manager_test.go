@@ -0,0 +1,290 @@
+package plugintest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+)
+
+// copyExecutable copies the executable at src to dst through a rename, like a deployment replacing a binary
+func copyExecutable(t *testing.T, src, dst string) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newManager(t *testing.T, opt plugin.ManagerOptions) *plugin.Manager {
+	t.Helper()
+
+	opt.Default = &plugin.DynamicPlugin[any]{}
+	m := plugin.NewManager(plugintest.NewFakeCatalogStore(), opt)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		m.ShutdownAll(ctx)
+	})
+	return m
+}
+
+func callEcho(t *testing.T, m *plugin.Manager, name string) {
+	t.Helper()
+
+	c, ok := m.Get(name)
+	if !ok {
+		t.Fatalf("plugin %s is not loaded", name)
+	}
+	if _, err := c.(*plugin.DynamicClient).Call("Echo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadManifestRelativePaths(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyExecutable(t, path, filepath.Join(dir, "bin", "echo"))
+
+	manifest := filepath.Join(dir, "plugins.yaml")
+	if err := os.WriteFile(manifest, []byte("plugins:\n  - name: echo\n    path: bin/echo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newManager(t, plugin.ManagerOptions{})
+	if err := m.LoadManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	callEcho(t, m, "echo")
+}
+
+func TestLoadDir(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	dir := t.TempDir()
+	copyExecutable(t, path, filepath.Join(dir, "echo"))
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newManager(t, plugin.ManagerOptions{})
+	if err := m.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if names := m.List(); len(names) != 1 || names[0] != "echo" {
+		t.Fatalf("expected only the executable to be loaded, got %v", names)
+	}
+}
+
+func TestLoadDuplicateName(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+
+	m := newManager(t, plugin.ManagerOptions{})
+	err := m.LoadAll([]plugin.ManifestEntry{{Name: "echo", Path: path}, {Name: "echo", Path: path}})
+	if err == nil || !strings.Contains(err.Error(), "already loaded") {
+		t.Fatalf("expected the second plugin named echo to be rejected, got %v", err)
+	}
+	callEcho(t, m, "echo")
+}
+
+func TestLoadAllPartialFailure(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+
+	m := newManager(t, plugin.ManagerOptions{})
+	err := m.LoadAll([]plugin.ManifestEntry{
+		{Name: "echo", Path: path},
+		{Name: "broken", Path: plugintest.MalformedHandshake(t)},
+	})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the broken plugin to fail, got %v", err)
+	}
+	if names := m.List(); len(names) != 1 || names[0] != "echo" {
+		t.Fatalf("expected echo to stay loaded, got %v", names)
+	}
+	callEcho(t, m, "echo")
+}
+
+// reloads records the results reported to ManagerOptions.OnReload
+type reloads struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *reloads) record(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *reloads) get() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error{}, r.errs...)
+}
+
+// wait waits until n reloads were reported
+func (r *reloads) wait(t *testing.T, n int) []error {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if errs := r.get(); len(errs) >= n {
+			return errs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d reloads, got %d", n, len(r.get()))
+	return nil
+}
+
+func watch(t *testing.T, m *plugin.Manager, interval time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Watch(ctx, interval)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestWatchWaitsForBinaryToSettle(t *testing.T) {
+	built := plugintest.BuildPlugin(t, "./testdata/echo")
+	path := filepath.Join(t.TempDir(), "echo")
+	copyExecutable(t, built, path)
+
+	var r reloads
+	m := newManager(t, plugin.ManagerOptions{OnReload: r.record})
+	if err := m.Load(plugin.ManifestEntry{Name: "echo", Path: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	interval := 100 * time.Millisecond
+	watch(t, m, interval)
+
+	// A binary that changes on every check is still being written and is not reloaded
+	modTime := time.Now()
+	for i := 0; i < 8; i++ {
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(interval / 2)
+	}
+	if errs := r.get(); len(errs) != 0 {
+		t.Fatalf("expected no reload while the binary keeps changing, got %v", errs)
+	}
+
+	if errs := r.wait(t, 1); errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	callEcho(t, m, "echo")
+}
+
+func TestWatchDoesNotRetryFailedBinary(t *testing.T) {
+	built := plugintest.BuildPlugin(t, "./testdata/echo")
+	path := filepath.Join(t.TempDir(), "echo")
+	copyExecutable(t, built, path)
+
+	var r reloads
+	m := newManager(t, plugin.ManagerOptions{OnReload: r.record})
+	if err := m.Load(plugin.ManifestEntry{Name: "echo", Path: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	interval := 50 * time.Millisecond
+	watch(t, m, interval)
+
+	copyExecutable(t, plugintest.MalformedHandshake(t), path)
+	if errs := r.wait(t, 1); errs[0] == nil {
+		t.Fatal("expected the reload of a broken binary to fail")
+	}
+
+	// The broken binary is not reloaded again until it changes, and the old process keeps serving
+	time.Sleep(10 * interval)
+	if errs := r.get(); len(errs) != 1 {
+		t.Fatalf("expected a single failed reload, got %v", errs)
+	}
+	callEcho(t, m, "echo")
+
+	copyExecutable(t, built, path)
+	if errs := r.wait(t, 2); errs[1] != nil {
+		t.Fatal(errs[1])
+	}
+	callEcho(t, m, "echo")
+}
+
+// processesUnder returns the pids of the processes whose command line mentions dir
+func processesUnder(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		t.Skip("listing processes needs /proc")
+	}
+
+	var pids []string
+	for _, e := range entries {
+		cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err == nil && strings.Contains(string(cmdline), dir) {
+			pids = append(pids, e.Name())
+		}
+	}
+	return pids
+}
+
+func TestShutdownDuringReload(t *testing.T) {
+	built := plugintest.BuildPlugin(t, "./testdata/echo")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "echo")
+	copyExecutable(t, built, path)
+
+	var r reloads
+	m := newManager(t, plugin.ManagerOptions{OnReload: r.record})
+	if err := m.Load(plugin.ManifestEntry{Name: "echo", Path: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	interval := 50 * time.Millisecond
+	watch(t, m, interval)
+
+	// The new process takes a second to start, ShutdownAll runs while the reload waits for it
+	copyExecutable(t, plugintest.SlowHandshake(t, built, time.Second), path)
+	time.Sleep(8 * interval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.ShutdownAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := r.wait(t, 1); errs[0] == nil {
+		t.Fatal("expected the reload to fail once the plugin was shut down")
+	}
+	if pids := processesUnder(t, filepath.Dir(built)); len(pids) != 0 {
+		t.Fatalf("expected no plugin process after ShutdownAll, found %v", pids)
+	}
+	if pids := processesUnder(t, dir); len(pids) != 0 {
+		t.Fatalf("expected no plugin process after ShutdownAll, found %v", pids)
+	}
+}
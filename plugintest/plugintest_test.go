@@ -0,0 +1,67 @@
+package plugintest_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+)
+
+func TestLoadPlugin(t *testing.T) {
+	cs := plugintest.NewFakeCatalogStore()
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+
+	c := plugintest.LoadPlugin(t, cs, plugin.PluginLoadOptions{Name: "echo", Path: path, Plugin: &plugin.DynamicPlugin[any]{}})
+
+	out, err := c.(*plugin.DynamicClient).Call("Echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != "hello" {
+		t.Fatalf("unexpected results %v", out)
+	}
+	plugintest.AssertRegistered(t, cs, "echo")
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	slow := plugintest.SlowHandshake(t, path, 5*time.Second)
+
+	cs := plugintest.NewFakeCatalogStore()
+	start := time.Now()
+	_, err := plugin.Load(plugin.PluginLoadOptions{
+		Name:             "echo",
+		Path:             slow,
+		Plugin:           &plugin.DynamicPlugin[any]{},
+		HandshakeTimeout: 200 * time.Millisecond,
+	}, cs)
+	if err == nil || !strings.Contains(err.Error(), "handshake") {
+		t.Fatalf("expected a handshake timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("load took %s, the handshake timeout was not applied", elapsed)
+	}
+	plugintest.AssertNotRegistered(t, cs, "echo")
+}
+
+func TestSlowHandshakeWithinTimeout(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	slow := plugintest.SlowHandshake(t, path, 200*time.Millisecond)
+
+	cs := plugintest.NewFakeCatalogStore()
+	plugintest.LoadPlugin(t, cs, plugin.PluginLoadOptions{Name: "echo", Path: slow, Plugin: &plugin.DynamicPlugin[any]{}, HandshakeTimeout: 5 * time.Second})
+	plugintest.AssertRegistered(t, cs, "echo")
+}
+
+func TestMalformedHandshake(t *testing.T) {
+	_, err := plugin.Load(plugin.PluginLoadOptions{
+		Name:   "broken",
+		Path:   plugintest.MalformedHandshake(t),
+		Plugin: &plugin.DynamicPlugin[any]{},
+	}, plugintest.NewFakeCatalogStore())
+	if err == nil {
+		t.Fatal("expected a malformed handshake to fail")
+	}
+}
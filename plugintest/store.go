@@ -0,0 +1,109 @@
+package plugintest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/cvhariharan/plugin/store"
+)
+
+// Registration is a call to FakeCatalogStore.Add
+type Registration struct {
+	Name    string
+	Service store.ServiceInfo
+	OK      bool
+}
+
+// FakeCatalogStore is an in-memory CatalogStore that records registrations and can be made to fail
+type FakeCatalogStore struct {
+	mu            sync.Mutex
+	services      map[string]store.ServiceInfo
+	registrations []Registration
+
+	// FailAdd makes Add reject registrations of the names for which it returns true
+	FailAdd func(name string) bool
+}
+
+// NewFakeCatalogStore returns an empty FakeCatalogStore
+func NewFakeCatalogStore() *FakeCatalogStore {
+	return &FakeCatalogStore{services: make(map[string]store.ServiceInfo)}
+}
+
+func (f *FakeCatalogStore) Add(name string, s store.ServiceInfo) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ok := f.FailAdd == nil || !f.FailAdd(name)
+	if ok {
+		f.services[name] = s
+	}
+	f.registrations = append(f.registrations, Registration{Name: name, Service: s, OK: ok})
+	return ok
+}
+
+func (f *FakeCatalogStore) Get(name string) (store.ServiceInfo, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.services[name]
+	return s, ok
+}
+
+// List returns a copy of the services in the store
+func (f *FakeCatalogStore) List() map[string]store.ServiceInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	services := make(map[string]store.ServiceInfo, len(f.services))
+	for name, s := range f.services {
+		services[name] = s
+	}
+	return services
+}
+
+// Remove removes the named service and reports whether it was in the store
+func (f *FakeCatalogStore) Remove(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.services[name]
+	delete(f.services, name)
+	return ok
+}
+
+// Len returns the number of services in the store
+func (f *FakeCatalogStore) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.services)
+}
+
+// Registrations returns every call made to Add, including rejected ones, in order
+func (f *FakeCatalogStore) Registrations() []Registration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Registration{}, f.registrations...)
+}
+
+// AssertRegistered fails the test if name is not in cs and returns its service info
+func AssertRegistered(t testing.TB, cs store.CatalogStore, name string) store.ServiceInfo {
+	t.Helper()
+	s, ok := cs.Get(name)
+	if !ok {
+		t.Fatalf("service %s is not registered in the catalog", name)
+	}
+	return s
+}
+
+// AssertNotRegistered fails the test if name is in cs
+func AssertNotRegistered(t testing.TB, cs store.CatalogStore, name string) {
+	t.Helper()
+	if s, ok := cs.Get(name); ok {
+		t.Fatalf("service %s is registered in the catalog at %s", name, s.Address)
+	}
+}
+
+// AssertAddress fails the test if name is not registered in cs with address
+func AssertAddress(t testing.TB, cs store.CatalogStore, name, address string) {
+	t.Helper()
+	if s := AssertRegistered(t, cs, name); s.Address != address {
+		t.Fatalf("service %s is registered at %s, expected %s", name, s.Address, address)
+	}
+}
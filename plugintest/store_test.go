@@ -0,0 +1,58 @@
+package plugintest_test
+
+import (
+	"testing"
+
+	"github.com/cvhariharan/plugin/catalog"
+	"github.com/cvhariharan/plugin/plugintest"
+	"github.com/cvhariharan/plugin/store"
+)
+
+func TestFakeCatalogStore(t *testing.T) {
+	cs := plugintest.NewFakeCatalogStore()
+	cs.FailAdd = func(name string) bool { return name == "broken" }
+
+	svc := store.ServiceInfo{Address: "127.0.0.1:10000", Socket: store.TCP}
+	if !cs.Add("hello", svc) {
+		t.Fatal("expected hello to be added")
+	}
+	if cs.Add("broken", svc) {
+		t.Fatal("expected broken to be rejected")
+	}
+
+	plugintest.AssertAddress(t, cs, "hello", "127.0.0.1:10000")
+	plugintest.AssertNotRegistered(t, cs, "broken")
+
+	regs := cs.Registrations()
+	if len(regs) != 2 || !regs[0].OK || regs[1].OK || regs[1].Name != "broken" {
+		t.Fatalf("unexpected registrations %+v", regs)
+	}
+
+	if !cs.Remove("hello") || cs.Remove("hello") {
+		t.Fatal("expected hello to be removed once")
+	}
+	if cs.Len() != 0 {
+		t.Fatalf("expected an empty store, got %d services", cs.Len())
+	}
+}
+
+func TestStartCatalog(t *testing.T) {
+	cs := plugintest.NewFakeCatalogStore()
+	address := plugintest.StartCatalog(t, cs)
+
+	c, err := catalog.Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if !c.Add("hello", store.ServiceInfo{Address: "/tmp/hello.sock", Socket: store.UNIX}) {
+		t.Fatal("could not register with the catalog")
+	}
+	plugintest.AssertAddress(t, cs, "hello", "/tmp/hello.sock")
+
+	s, ok := c.Get("hello")
+	if !ok || s.Socket != store.UNIX {
+		t.Fatalf("unexpected service %+v", s)
+	}
+}
@@ -0,0 +1,22 @@
+// echo is a dynamic plugin served with fault injection, used by the tests of plugintest
+package main
+
+import (
+	"log"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+)
+
+type Echo struct{}
+
+func (Echo) Echo(s string) (string, error) {
+	return s, nil
+}
+
+func main() {
+	err := plugin.Serve(&plugin.DynamicPlugin[Echo]{Impl: Echo{}}, plugintest.WithFaults(plugin.PluginServeOptions{Name: "echo"}))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
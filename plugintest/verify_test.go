@@ -0,0 +1,57 @@
+package plugintest_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+)
+
+func TestLoadVerifiedPlugin(t *testing.T) {
+	path := plugintest.BuildPlugin(t, "./testdata/echo")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+
+	execDir := t.TempDir()
+	m := plugin.NewManager(plugintest.NewFakeCatalogStore(), plugin.ManagerOptions{
+		Default:     &plugin.DynamicPlugin[any]{},
+		LoadOptions: plugin.PluginLoadOptions{ExecDir: execDir},
+	})
+	if err := m.Load(plugin.ManifestEntry{Name: "echo", Path: path, Checksum: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ := os.ReadDir(execDir); len(entries) != 1 {
+		t.Fatalf("expected the plugin to run from a copy in %s, found %d entries", execDir, len(entries))
+	}
+	c, _ := m.Get("echo")
+	if _, err := c.(*plugin.DynamicClient).Call("Echo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ShutdownAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(execDir); len(entries) != 0 {
+		t.Fatalf("expected the copy to be removed once the plugin stops, found %d entries", len(entries))
+	}
+}
+
+func TestManagerRejectsBaseChecksum(t *testing.T) {
+	m := plugin.NewManager(plugintest.NewFakeCatalogStore(), plugin.ManagerOptions{
+		Default:     &plugin.DynamicPlugin[any]{},
+		LoadOptions: plugin.PluginLoadOptions{Checksum: strings.Repeat("0", 64)},
+	})
+	err := m.Load(plugin.ManifestEntry{Name: "echo", Path: "/nonexistent/echo"})
+	if err == nil || !strings.Contains(err.Error(), "manifest entry") {
+		t.Fatalf("expected a checksum in the load options to be rejected, got %v", err)
+	}
+}
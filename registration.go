@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cvhariharan/plugin/catalog"
+	"github.com/cvhariharan/plugin/catalog/protogen"
+	"github.com/cvhariharan/plugin/mdns"
+	"github.com/cvhariharan/plugin/store"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DEFAULT_REGISTER_INTERVAL is shorter than the usual catalog lease TTL, so a lease is renewed before it expires
+	DEFAULT_REGISTER_INTERVAL = 30 * time.Second
+
+	// Backoff between failed registrations, doubling up to MAX_REGISTER_BACKOFF
+	REGISTER_BACKOFF     = 500 * time.Millisecond
+	MAX_REGISTER_BACKOFF = 30 * time.Second
+)
+
+// registration keeps a plugin registered with a catalog. It registers again every interval,
+// which renews leases and restores the service in catalogs that lost it, and as soon as the
+// watch stream reconnects, since that means the catalog may have restarted.
+type registration struct {
+	client   *catalog.Client
+	name     string
+	svc      store.ServiceInfo
+	interval time.Duration
+	timeout  time.Duration
+
+	// identity and key sign each registration if identity is set
+	identity string
+	key      []byte
+
+	// onRegister, if set, is called with the result of every attempt
+	onRegister func(err error)
+}
+
+// discoveryAddresses returns the discovery servers listed in PLUGIN_DISCOVERY_ADDRESS
+func discoveryAddresses() []string {
+	var addresses []string
+	for _, address := range strings.Split(os.Getenv(PLUGIN_DISCOVERY_ADDRESS), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// newRegistration connects to the discovery server at address to register svc under the name of the plugin
+func newRegistration(opt PluginServeOptions, t *telemetry, address string, svc store.ServiceInfo) (*registration, error) {
+	interval := opt.RegisterInterval
+	if interval == 0 {
+		interval = DEFAULT_REGISTER_INTERVAL
+		if env := os.Getenv(PLUGIN_REGISTER_INTERVAL); env != "" {
+			var err error
+			interval, err = time.ParseDuration(env)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s as duration: %v", PLUGIN_REGISTER_INTERVAL, err)
+			}
+		}
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("register interval must be positive")
+	}
+
+	var dialOpts []grpc.DialOption
+	if token := envDefault(opt.CatalogToken, PLUGIN_CATALOG_TOKEN); token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(TokenCredentials(token)))
+	}
+	dialOpts = append(dialOpts, opt.CatalogDialOptions...)
+
+	// Failed registrations are retried by run, with a backoff that keeps growing while the catalog is down
+	client, err := catalog.DialWithOptions(address, catalog.ClientOptions{
+		DialOptions:    dialOpts,
+		Retries:        -1,
+		TracerProvider: t.tp,
+		MeterProvider:  t.mp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to discovery server: %v", err)
+	}
+
+	key := opt.CatalogKey
+	if key == nil {
+		key = []byte(os.Getenv(PLUGIN_CATALOG_KEY))
+	}
+
+	return &registration{
+		client:     client,
+		name:       opt.Name,
+		svc:        svc,
+		interval:   interval,
+		timeout:    catalog.DEFAULT_CLIENT_TIMEOUT,
+		identity:   envDefault(opt.CatalogIdentity, PLUGIN_CATALOG_IDENTITY),
+		key:        key,
+		onRegister: opt.OnRegister,
+	}, nil
+}
+
+// run registers until ctx is done, retrying failed registrations with backoff
+func (r *registration) run(ctx context.Context) {
+	subscribed := make(chan struct{}, 1)
+	go r.watch(ctx, subscribed)
+
+	backoff := REGISTER_BACKOFF
+	for {
+		wait := r.interval
+		if err := r.register(ctx); err != nil {
+			wait = backoff
+			backoff = min(backoff*2, MAX_REGISTER_BACKOFF)
+		} else {
+			backoff = REGISTER_BACKOFF
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-subscribed:
+		}
+	}
+}
+
+// register makes a single registration attempt
+func (r *registration) register(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if r.identity != "" {
+		req := &protogen.Service{Name: r.name, Address: r.svc.Address, SocketType: protogen.SocketType_TCP}
+		if r.svc.Socket == store.UNIX {
+			req.SocketType = protogen.SocketType_UNIX
+		}
+		ctx = catalog.SignedRegistrationContext(ctx, r.key, r.identity, req)
+	}
+
+	err := r.client.AddContext(ctx, r.name, r.svc)
+	if r.onRegister != nil && ctx.Err() != context.Canceled {
+		r.onRegister(err)
+	}
+	return err
+}
+
+// watch signals subscribed every time the watch stream of the catalog is (re)established
+func (r *registration) watch(ctx context.Context, subscribed chan<- struct{}) {
+	backoff := REGISTER_BACKOFF
+	for ctx.Err() == nil {
+		start := time.Now()
+		r.client.WatchContext(ctx, func(e *protogen.Event) {
+			if e.Type != protogen.EventType_SUBSCRIBED {
+				return
+			}
+			select {
+			case subscribed <- struct{}{}:
+			default:
+			}
+		})
+
+		// A stream that lasted a while was healthy, so reconnect quickly
+		if time.Since(start) > MAX_REGISTER_BACKOFF {
+			backoff = REGISTER_BACKOFF
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, MAX_REGISTER_BACKOFF)
+	}
+}
+
+// advertiseOptions returns the mDNS options of the plugin, nil if it is not advertised
+func advertiseOptions(opt PluginServeOptions) (*mdns.Options, error) {
+	if opt.MDNS != nil {
+		return opt.MDNS, nil
+	}
+
+	env := os.Getenv(PLUGIN_MDNS)
+	if env == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(env)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s as bool: %v", PLUGIN_MDNS, err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return &mdns.Options{}, nil
+}
@@ -0,0 +1,140 @@
+package plugin_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/catalog"
+	"github.com/cvhariharan/plugin/plugintest"
+	"github.com/cvhariharan/plugin/store"
+)
+
+// serveRegistered serves Sleeper until the end of the test, registering it with the catalogs at addresses
+func serveRegistered(t *testing.T, opt plugin.PluginServeOptions, addresses ...string) {
+	t.Helper()
+	t.Setenv(plugin.PLUGIN_SOCKET_TYPE, plugin.SOCKET_TYPE_UNIX)
+	t.Setenv(plugin.PLUGIN_SOCKET_DIR, t.TempDir())
+	t.Setenv(plugin.PLUGIN_DISCOVERY_ADDRESS, strings.Join(addresses, ", "))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	t.Cleanup(func() {
+		cancel()
+		<-errc
+	})
+
+	opt.Name = "sleeper"
+	go func() {
+		errc <- plugin.ServeContext(ctx, &plugin.DynamicPlugin[Sleeper]{}, opt)
+	}()
+
+	if !bufio.NewScanner(r).Scan() {
+		t.Fatal("no handshake")
+	}
+}
+
+// startCatalogAt serves cs as a catalog on address, it is stopped by the returned function or at the end of the test
+func startCatalogAt(t *testing.T, cs store.CatalogStore, address string) func() {
+	t.Helper()
+
+	srv, err := catalog.NewServer(cs, catalog.Options{Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := func() {
+		srv.Stop(context.Background())
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitRegistered waits until sleeper is registered in cs
+func waitRegistered(t *testing.T, cs store.CatalogStore) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := cs.Get("sleeper"); ok {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("sleeper was not registered")
+}
+
+func TestRegisterRetriesWhileCatalogDown(t *testing.T) {
+	address := freeAddress(t)
+
+	failed := make(chan error, 1)
+	serveRegistered(t, plugin.PluginServeOptions{OnRegister: func(err error) {
+		if err != nil {
+			select {
+			case failed <- err:
+			default:
+			}
+		}
+	}}, address)
+
+	select {
+	case <-failed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the registration to fail while the catalog is down")
+	}
+
+	cs := store.NewMemCatalogStore()
+	startCatalogAt(t, cs, address)
+	waitRegistered(t, cs)
+}
+
+func TestRegisterAfterCatalogRestart(t *testing.T) {
+	address := freeAddress(t)
+	first := store.NewMemCatalogStore()
+	stop := startCatalogAt(t, first, address)
+
+	// The interval is too long to matter, registering again is up to the watch stream
+	serveRegistered(t, plugin.PluginServeOptions{RegisterInterval: time.Hour}, address)
+	waitRegistered(t, first)
+
+	stop()
+	restarted := store.NewMemCatalogStore()
+	startCatalogAt(t, restarted, address)
+	waitRegistered(t, restarted)
+}
+
+func TestRegisterWithSeveralCatalogs(t *testing.T) {
+	first, second := store.NewMemCatalogStore(), store.NewMemCatalogStore()
+	serveRegistered(t, plugin.PluginServeOptions{}, plugintest.StartCatalog(t, first), plugintest.StartCatalog(t, second))
+
+	waitRegistered(t, first)
+	waitRegistered(t, second)
+}
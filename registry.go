@@ -31,6 +31,17 @@ func RegisterType(value interface{}) {
 	globalRegistry.types[t.String()] = t
 }
 
+// isRegistered checks if t, or the type it points to, is in the global registry
+func isRegistered(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	globalRegistry.mu.RLock()
+	defer globalRegistry.mu.RUnlock()
+	registered, ok := globalRegistry.types[t.String()]
+	return ok && registered == t
+}
+
 // SerializeObject serializes an object to bytes
 func SerializeObject(obj interface{}) ([]byte, string, error) {
 	t := reflect.TypeOf(obj)
@@ -0,0 +1,50 @@
+package plugin
+
+// SandboxOptions restrict the privileges and resources of a plugin process.
+// Sandboxing is only supported on Linux, loading a sandboxed plugin fails on other platforms.
+type SandboxOptions struct {
+	// Credential is the user and groups the plugin runs as. The host needs the privileges to switch to them.
+	// Supplementary groups cannot be set in a new user namespace, loading fails if Groups is set along with NewUserNamespace.
+	Credential *Credential
+
+	// New namespaces the plugin is started in. A new user namespace maps the host user to
+	// Credential, or to the same IDs if Credential is not set, so it does not require privileges.
+	// A plugin in a new network namespace can only be reached over a unix socket.
+	NewUserNamespace    bool
+	NewPIDNamespace     bool
+	NewNetworkNamespace bool
+	NewMountNamespace   bool
+	NewIPCNamespace     bool
+	NewUTSNamespace     bool
+
+	// Resource limits applied with prlimit, zero means unlimited.
+	// Go cannot set rlimits between fork and exec, so they are applied to the process once it has started:
+	// the plugin runs without them from exec until Load applies them, typically for well under a millisecond,
+	// which covers the dynamic loader and the start of its runtime. Use CgroupParent with CgroupMemoryMax
+	// and CgroupCPUMax for limits that hold from the first instruction, since the process starts in its cgroup.
+	CPUSeconds  uint64
+	MemoryBytes uint64
+	OpenFiles   uint64
+
+	// CgroupParent is a cgroup v2 directory, like /sys/fs/cgroup/plugins, under which a cgroup is
+	// created for the plugin. The plugin is started directly in it and it is removed when the plugin exits.
+	// The parent must have the memory and cpu controllers enabled in cgroup.subtree_control.
+	CgroupParent string
+
+	// CgroupMemoryMax is written to memory.max, zero means no limit
+	CgroupMemoryMax int64
+
+	// CgroupCPUMax is written to cpu.max, in the "$MAX $PERIOD" format, e.g. "50000 100000" for half a CPU
+	CgroupCPUMax string
+
+	// KillWithHost kills the plugin when the host process dies.
+	// The kernel delivers the signal when the thread that started the plugin exits, see https://go.dev/issue/27505.
+	KillWithHost bool
+}
+
+// Credential is a user and its groups
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
@@ -0,0 +1,175 @@
+//go:build linux
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/lithammer/shortuuid"
+	"golang.org/x/sys/unix"
+)
+
+// sandbox applies SandboxOptions to a plugin process
+type sandbox struct {
+	opt       *SandboxOptions
+	cgroupDir string
+	cgroup    *os.File
+}
+
+// newSandbox returns the sandbox for opt, or nil if opt is nil
+func newSandbox(name string, opt *SandboxOptions) (*sandbox, error) {
+	if opt == nil {
+		return nil, nil
+	}
+
+	// setgroups is denied in a user namespace created without privileges, see user_namespaces(7)
+	if opt.NewUserNamespace && opt.Credential != nil && len(opt.Credential.Groups) > 0 {
+		return nil, fmt.Errorf("sandbox of %s cannot set supplementary groups in a new user namespace", name)
+	}
+
+	s := &sandbox{opt: opt}
+	if opt.CgroupParent != "" {
+		if err := s.createCgroup(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// prepare sets the attributes of cmd that have to be in place before it starts
+func (s *sandbox) prepare(cmd *exec.Cmd) {
+	if s == nil {
+		return
+	}
+
+	attr := &syscall.SysProcAttr{}
+	if c := s.opt.Credential; c != nil {
+		attr.Credential = &syscall.Credential{Uid: c.UID, Gid: c.GID, Groups: c.Groups}
+	}
+
+	for _, ns := range []struct {
+		enabled bool
+		flag    uintptr
+	}{
+		{s.opt.NewUserNamespace, syscall.CLONE_NEWUSER},
+		{s.opt.NewPIDNamespace, syscall.CLONE_NEWPID},
+		{s.opt.NewNetworkNamespace, syscall.CLONE_NEWNET},
+		{s.opt.NewMountNamespace, syscall.CLONE_NEWNS},
+		{s.opt.NewIPCNamespace, syscall.CLONE_NEWIPC},
+		{s.opt.NewUTSNamespace, syscall.CLONE_NEWUTS},
+	} {
+		if ns.enabled {
+			attr.Cloneflags |= ns.flag
+		}
+	}
+
+	if s.opt.NewUserNamespace {
+		uid, gid := os.Getuid(), os.Getgid()
+		if c := s.opt.Credential; c != nil {
+			uid, gid = int(c.UID), int(c.GID)
+		}
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+
+	if s.cgroup != nil {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(s.cgroup.Fd())
+	}
+
+	if s.opt.KillWithHost {
+		attr.Pdeathsig = syscall.SIGKILL
+	}
+
+	cmd.SysProcAttr = attr
+}
+
+// started applies the resource limits to the running process, which ran without them since exec,
+// see SandboxOptions for the window and the cgroup limits that do not have one
+func (s *sandbox) started(pid int) error {
+	if s == nil {
+		return nil
+	}
+
+	for resource, limit := range map[int]uint64{
+		unix.RLIMIT_CPU:    s.opt.CPUSeconds,
+		unix.RLIMIT_AS:     s.opt.MemoryBytes,
+		unix.RLIMIT_NOFILE: s.opt.OpenFiles,
+	} {
+		if limit == 0 {
+			continue
+		}
+
+		rlimit := &unix.Rlimit{Cur: limit, Max: limit}
+		if err := unix.Prlimit(pid, resource, rlimit, nil); err != nil {
+			return fmt.Errorf("could not set resource limit %d on plugin: %v", resource, err)
+		}
+	}
+
+	return nil
+}
+
+// peerIDs translates the uid and pid of the host to the ones the plugin sees on its connections.
+// The host pid is not visible in a new PID namespace, so it is reported as 0 and not checked.
+func (s *sandbox) peerIDs(uid, pid int) (int, int) {
+	if s == nil {
+		return uid, pid
+	}
+
+	if s.opt.NewUserNamespace && s.opt.Credential != nil {
+		uid = int(s.opt.Credential.UID)
+	}
+	if s.opt.NewPIDNamespace {
+		pid = 0
+	}
+	return uid, pid
+}
+
+// cleanup removes the cgroup of the plugin, which has to have exited
+func (s *sandbox) cleanup() {
+	if s == nil || s.cgroup == nil {
+		return
+	}
+
+	s.cgroup.Close()
+	os.Remove(s.cgroupDir)
+}
+
+// createCgroup creates the cgroup of the plugin and writes its limits
+func (s *sandbox) createCgroup(name string) error {
+	dir := filepath.Join(s.opt.CgroupParent, fmt.Sprintf("%s-%s", name, shortuuid.New()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return fmt.Errorf("could not create cgroup: %v", err)
+	}
+	s.cgroupDir = dir
+
+	limits := map[string]string{}
+	if s.opt.CgroupMemoryMax > 0 {
+		limits["memory.max"] = fmt.Sprintf("%d", s.opt.CgroupMemoryMax)
+	}
+	if s.opt.CgroupCPUMax != "" {
+		limits["cpu.max"] = s.opt.CgroupCPUMax
+	}
+
+	for file, value := range limits {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			os.Remove(dir)
+			return fmt.Errorf("could not set %s on cgroup: %v", file, err)
+		}
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("could not open cgroup: %v", err)
+	}
+	s.cgroup = f
+
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package plugin
+
+import "testing"
+
+func TestSandboxRejectsGroupsInUserNamespace(t *testing.T) {
+	opt := &SandboxOptions{NewUserNamespace: true, Credential: &Credential{UID: 1000, GID: 1000, Groups: []uint32{1000}}}
+	if _, err := newSandbox("echo", opt); err == nil {
+		t.Fatal("expected supplementary groups in a new user namespace to be rejected")
+	}
+
+	opt.Credential.Groups = nil
+	if _, err := newSandbox("echo", opt); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+type sandbox struct{}
+
+func newSandbox(name string, opt *SandboxOptions) (*sandbox, error) {
+	if opt != nil {
+		return nil, fmt.Errorf("plugin sandboxing is only supported on linux")
+	}
+	return nil, nil
+}
+
+func (s *sandbox) prepare(cmd *exec.Cmd) {}
+
+func (s *sandbox) started(pid int) error { return nil }
+
+func (s *sandbox) peerIDs(uid, pid int) (int, int) { return uid, pid }
+
+func (s *sandbox) cleanup() {}
@@ -0,0 +1,92 @@
+package plugin_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/plugintest"
+)
+
+type Sleeper struct{}
+
+func (Sleeper) Sleep(ms int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// serveSleeper serves Sleeper on a unix socket with ServeContext and returns a client and the result of ServeContext
+func serveSleeper(t *testing.T, ctx context.Context, stopTimeout time.Duration) (*plugin.DynamicClient, <-chan error) {
+	t.Helper()
+	t.Setenv(plugin.PLUGIN_SOCKET_TYPE, plugin.SOCKET_TYPE_UNIX)
+	t.Setenv(plugin.PLUGIN_SOCKET_DIR, t.TempDir())
+
+	// The handshake is printed on stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- plugin.ServeContext(ctx, &plugin.DynamicPlugin[Sleeper]{}, plugin.PluginServeOptions{Name: "sleeper", StopTimeout: stopTimeout})
+	}()
+
+	var resp plugin.PluginResponse
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &resp) != nil {
+		t.Fatalf("no handshake: %v", scanner.Err())
+	}
+
+	c := plugintest.LoadPlugin(t, plugintest.NewFakeCatalogStore(), plugin.PluginLoadOptions{
+		Name:    "sleeper",
+		Address: "unix://" + resp.Address,
+		Plugin:  &plugin.DynamicPlugin[Sleeper]{},
+	})
+	return c.(*plugin.DynamicClient), errc
+}
+
+func TestServeContextDrainsCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, errc := serveSleeper(t, ctx, 5*time.Second)
+
+	called := make(chan error, 1)
+	go func() {
+		_, err := c.Call("Sleep", 300)
+		called <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if err := <-called; err != nil {
+		t.Fatalf("expected the in flight call to finish, got %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeContextStopTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, errc := serveSleeper(t, ctx, 200*time.Millisecond)
+
+	go c.Call("Sleep", 5000)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server was not stopped after the stop timeout")
+	}
+}
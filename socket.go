@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// createSocketDir returns the directory a plugin creates its unix socket in and whether it was created for the plugin.
+// Directories created for a plugin are private to the user it runs as and are removed when it stops.
+func createSocketDir(opt PluginLoadOptions) (string, bool, error) {
+	if opt.SocketDir != "" {
+		if err := os.MkdirAll(opt.SocketDir, 0700); err != nil {
+			return "", false, fmt.Errorf("could not create socket directory: %v", err)
+		}
+		return opt.SocketDir, false, nil
+	}
+
+	dir, err := os.MkdirTemp("", "plugin-")
+	if err != nil {
+		return "", false, fmt.Errorf("could not create socket directory: %v", err)
+	}
+
+	// A plugin running as another user has to own the directory to create its socket in it.
+	// In a new user namespace the plugin runs as the host user mapped to Credential, which already owns it.
+	if opt.Sandbox != nil && opt.Sandbox.Credential != nil && !opt.Sandbox.NewUserNamespace {
+		if err := os.Chown(dir, int(opt.Sandbox.Credential.UID), int(opt.Sandbox.Credential.GID)); err != nil {
+			os.Remove(dir)
+			return "", false, fmt.Errorf("could not change owner of socket directory: %v", err)
+		}
+	}
+
+	return dir, true, nil
+}
+
+// dirListener removes the directory of its unix socket when it is closed
+type dirListener struct {
+	net.Listener
+	dir  string
+	once sync.Once
+}
+
+func (l *dirListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() {
+		os.Remove(l.dir)
+	})
+	return err
+}
+
+// peerListener closes connections that do not come from the expected user and process
+type peerListener struct {
+	net.Listener
+	uid int
+	pid int
+}
+
+// newPeerListener wraps lis so only connections from uid, and from pid if it is not empty or zero, are accepted
+func newPeerListener(lis net.Listener, uid, pid string) (net.Listener, error) {
+	l := &peerListener{Listener: lis}
+
+	var err error
+	l.uid, err = strconv.Atoi(uid)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s as int: %v", PLUGIN_PEER_UID, err)
+	}
+
+	if pid != "" {
+		l.pid, err = strconv.Atoi(pid)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s as int: %v", PLUGIN_PEER_PID, err)
+		}
+	}
+
+	return l, nil
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, pid, err := peerCredentials(conn)
+		if err == nil && uid == l.uid && (l.pid == 0 || pid == l.pid) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCredentials is not supported, so every connection is rejected when peer checks are requested
+func peerCredentials(conn net.Conn) (int, int, error) {
+	return 0, 0, fmt.Errorf("peer credentials are only supported on linux")
+}
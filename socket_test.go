@@ -0,0 +1,29 @@
+package plugin
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSocketDirOwnerInUserNamespace(t *testing.T) {
+	dir, created, err := createSocketDir(PluginLoadOptions{Sandbox: &SandboxOptions{
+		NewUserNamespace: true,
+		Credential:       &Credential{UID: 12345, GID: 12345},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dir)
+	if !created {
+		t.Fatal("expected a directory to be created for the plugin")
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := fi.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Getuid() {
+		t.Fatalf("expected the socket directory to stay owned by the host user %d, got %d", os.Getuid(), uid)
+	}
+}
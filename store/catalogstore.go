@@ -14,6 +14,9 @@ const (
 type ServiceInfo struct {
 	Address string
 	Socket  SocketType
+
+	// Origin identifies the catalog the service was registered with
+	Origin string
 }
 
 type CatalogStore interface {
@@ -21,6 +24,34 @@ type CatalogStore interface {
 	Get(name string) (ServiceInfo, bool)
 }
 
+// Lister is implemented by stores that can enumerate their services
+type Lister interface {
+	List() map[string]ServiceInfo
+}
+
+// Remover is implemented by stores that services can be removed from
+type Remover interface {
+	Remove(name string) bool
+}
+
+// ReadOnly is implemented by stores that can tell they do not accept registrations,
+// so the catalog can tell a read-only store apart from one rejecting a duplicate
+type ReadOnly interface {
+	ReadOnly() bool
+}
+
+// IsReadOnly reports whether cs is known to reject every registration
+func IsReadOnly(cs CatalogStore) bool {
+	ro, ok := cs.(ReadOnly)
+	return ok && ro.ReadOnly()
+}
+
+// Expirer is implemented by stores that remove services on their own, like LeaseCatalogStore.
+// The catalog server registers with OnExpire to tell its watchers about those removals.
+type Expirer interface {
+	OnExpire(fn func(name string))
+}
+
 type MemCatalogStore struct {
 	m  map[string]ServiceInfo
 	mu sync.Mutex
@@ -45,3 +76,30 @@ func (m *MemCatalogStore) Get(name string) (ServiceInfo, bool) {
 	p, ok := m.m[name]
 	return p, ok
 }
+
+// Len returns the number of services in the store
+func (m *MemCatalogStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.m)
+}
+
+// List returns a copy of the services in the store
+func (m *MemCatalogStore) List() map[string]ServiceInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	services := make(map[string]ServiceInfo, len(m.m))
+	for name, s := range m.m {
+		services[name] = s
+	}
+	return services
+}
+
+// Remove removes the named service and reports whether it was in the store
+func (m *MemCatalogStore) Remove(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.m[name]
+	delete(m.m, name)
+	return ok
+}
@@ -0,0 +1,237 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// WritePolicy selects the layers of a LayeredCatalogStore that Add writes to
+type WritePolicy int
+
+const (
+	// WRITE_FIRST adds to the first writable layer that accepts the service
+	WRITE_FIRST WritePolicy = iota
+
+	// WRITE_ALL adds to every writable layer and succeeds only if all of them accept the service.
+	// If a layer rejects it, the layers that accepted it are rolled back to the service they had before,
+	// or the service is removed from them. Layers that had none and are not Removers cannot be rolled back,
+	// so the write is only atomic if every writable layer is a Remover.
+	WRITE_ALL
+
+	// WRITE_ANY adds to every writable layer and succeeds if any of them accepts the service
+	WRITE_ANY
+)
+
+// Layer is a store in a LayeredCatalogStore
+type Layer struct {
+	Store CatalogStore
+
+	// TTL, if set, caches the services found in this layer for that long,
+	// which is useful for remote stores like catalog.Client
+	TTL time.Duration
+
+	// ReadOnly layers are only looked up, Add and Remove skip them.
+	// Layers whose store is read-only, like a StaticCatalogStore, are skipped too.
+	ReadOnly bool
+}
+
+// writable reports whether Add and Remove write to the layer
+func (layer Layer) writable() bool {
+	return !layer.ReadOnly && !IsReadOnly(layer.Store)
+}
+
+// LayeredOptions configures a LayeredCatalogStore
+type LayeredOptions struct {
+	// Write is the policy of Add, WRITE_FIRST by default
+	Write WritePolicy
+
+	// NegativeTTL, if set, caches services missing from every layer for that long.
+	// Adding or removing a service through the store drops its cached result.
+	NegativeTTL time.Duration
+}
+
+// LayeredCatalogStore chains stores, looking services up in each layer in order.
+// NewOverlayCatalogStore is the simpler case of two layers without caching.
+type LayeredCatalogStore struct {
+	layers []Layer
+	opt    LayeredOptions
+
+	mu    sync.Mutex
+	cache map[string]cached
+
+	// generation changes on every invalidation, so a lookup racing with Add or Remove is not cached
+	generation uint64
+}
+
+// cached is the result of a lookup, a miss if found is false
+type cached struct {
+	svc    ServiceInfo
+	found  bool
+	expiry time.Time
+}
+
+// NewLayeredCatalogStore returns a store looking services up in layers, in order
+func NewLayeredCatalogStore(opt LayeredOptions, layers ...Layer) *LayeredCatalogStore {
+	return &LayeredCatalogStore{
+		layers: layers,
+		opt:    opt,
+		cache:  make(map[string]cached),
+	}
+}
+
+// Add adds the service to the writable layers according to the write policy
+func (l *LayeredCatalogStore) Add(name string, s ServiceInfo) bool {
+	defer l.Invalidate(name)
+
+	added, writable := 0, 0
+	var accepted []rollback
+	for _, layer := range l.layers {
+		if !layer.writable() {
+			continue
+		}
+		writable++
+
+		var prev rollback
+		if l.opt.Write == WRITE_ALL {
+			prev.store = layer.Store
+			prev.svc, prev.found = layer.Store.Get(name)
+		}
+
+		if layer.Store.Add(name, s) {
+			added++
+			if l.opt.Write == WRITE_FIRST {
+				return true
+			}
+			accepted = append(accepted, prev)
+		} else if l.opt.Write == WRITE_ALL {
+			for _, r := range accepted {
+				r.undo(name)
+			}
+			return false
+		}
+	}
+
+	if l.opt.Write == WRITE_ALL {
+		return writable > 0 && added == writable
+	}
+	return added > 0
+}
+
+// rollback is the state of a layer before a WRITE_ALL add, restored if another layer rejects the service
+type rollback struct {
+	store CatalogStore
+	svc   ServiceInfo
+	found bool
+}
+
+// undo restores the service the layer had before, or removes the service if it had none
+func (r rollback) undo(name string) {
+	if r.found {
+		r.store.Add(name, r.svc)
+		return
+	}
+	if remover, ok := r.store.(Remover); ok {
+		remover.Remove(name)
+	}
+}
+
+// Get returns the cached result for name, or the service from the first layer that has it
+func (l *LayeredCatalogStore) Get(name string) (ServiceInfo, bool) {
+	c, ok, generation := l.cached(name)
+	if ok {
+		return c.svc, c.found
+	}
+
+	for _, layer := range l.layers {
+		if s, ok := layer.Store.Get(name); ok {
+			if layer.TTL > 0 {
+				l.store(name, cached{svc: s, found: true, expiry: time.Now().Add(layer.TTL)}, generation)
+			}
+			return s, true
+		}
+	}
+
+	if l.opt.NegativeTTL > 0 {
+		l.store(name, cached{expiry: time.Now().Add(l.opt.NegativeTTL)}, generation)
+	}
+	return ServiceInfo{}, false
+}
+
+// List returns the services of the layers that are Listers, earlier layers taking precedence
+func (l *LayeredCatalogStore) List() map[string]ServiceInfo {
+	services := make(map[string]ServiceInfo)
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		if lister, ok := l.layers[i].Store.(Lister); ok {
+			for name, s := range lister.List() {
+				services[name] = s
+			}
+		}
+	}
+	return services
+}
+
+// Remove removes the service from every writable layer that is a Remover, and reports whether any had it
+func (l *LayeredCatalogStore) Remove(name string) bool {
+	defer l.Invalidate(name)
+
+	removed := false
+	for _, layer := range l.layers {
+		if remover, ok := layer.Store.(Remover); ok && layer.writable() {
+			removed = remover.Remove(name) || removed
+		}
+	}
+	return removed
+}
+
+// Len returns the number of distinct services in the layers that are Listers
+func (l *LayeredCatalogStore) Len() int {
+	return len(l.List())
+}
+
+// ReadOnly reports whether no layer can be written to
+func (l *LayeredCatalogStore) ReadOnly() bool {
+	for _, layer := range l.layers {
+		if layer.writable() {
+			return false
+		}
+	}
+	return true
+}
+
+// Invalidate drops the cached result for name, e.g. when a watch reports that it changed
+func (l *LayeredCatalogStore) Invalidate(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.cache, name)
+	l.generation++
+}
+
+// Purge drops every cached result
+func (l *LayeredCatalogStore) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.cache)
+	l.generation++
+}
+
+// cached returns the unexpired cached result for name, along with the current generation
+func (l *LayeredCatalogStore) cached(name string) (cached, bool, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.cache[name]
+	if ok && time.Now().After(c.expiry) {
+		delete(l.cache, name)
+		ok = false
+	}
+	return c, ok, l.generation
+}
+
+// store caches the result of a lookup started at generation, unless the cache was invalidated since
+func (l *LayeredCatalogStore) store(name string, c cached, generation uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.generation == generation {
+		l.cache[name] = c
+	}
+}
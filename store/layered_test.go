@@ -0,0 +1,228 @@
+package store
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts lookups and can run a hook in the middle of one
+type countingStore struct {
+	CatalogStore
+	gets   atomic.Int32
+	onGet  func()
+	reject bool
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{CatalogStore: NewMemCatalogStore()}
+}
+
+func (c *countingStore) Add(name string, s ServiceInfo) bool {
+	return !c.reject && c.CatalogStore.Add(name, s)
+}
+
+func (c *countingStore) Get(name string) (ServiceInfo, bool) {
+	c.gets.Add(1)
+	if c.onGet != nil {
+		c.onGet()
+	}
+	return c.CatalogStore.Get(name)
+}
+
+func (c *countingStore) List() map[string]ServiceInfo {
+	return c.CatalogStore.(Lister).List()
+}
+
+func (c *countingStore) Remove(name string) bool {
+	return c.CatalogStore.(Remover).Remove(name)
+}
+
+var testService = ServiceInfo{Address: "127.0.0.1:10000", Socket: TCP}
+
+func TestLayeredCache(t *testing.T) {
+	remote := newCountingStore()
+	remote.Add("hello", testService)
+	l := NewLayeredCatalogStore(LayeredOptions{}, Layer{Store: remote, TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.Get("hello"); !ok {
+			t.Fatal("expected hello to be found")
+		}
+	}
+	if n := remote.gets.Load(); n != 1 {
+		t.Fatalf("expected a single lookup, got %d", n)
+	}
+
+	l.Invalidate("hello")
+	l.Get("hello")
+	if n := remote.gets.Load(); n != 2 {
+		t.Fatalf("expected Invalidate to force a lookup, got %d lookups", n)
+	}
+
+	l.Purge()
+	l.Get("hello")
+	if n := remote.gets.Load(); n != 3 {
+		t.Fatalf("expected Purge to force a lookup, got %d lookups", n)
+	}
+}
+
+func TestLayeredCacheExpiry(t *testing.T) {
+	remote := newCountingStore()
+	remote.Add("hello", testService)
+	l := NewLayeredCatalogStore(LayeredOptions{}, Layer{Store: remote, TTL: 50 * time.Millisecond})
+
+	l.Get("hello")
+	time.Sleep(100 * time.Millisecond)
+	l.Get("hello")
+	if n := remote.gets.Load(); n != 2 {
+		t.Fatalf("expected an expired result to be looked up again, got %d lookups", n)
+	}
+}
+
+func TestLayeredNegativeCache(t *testing.T) {
+	remote := newCountingStore()
+	l := NewLayeredCatalogStore(LayeredOptions{NegativeTTL: time.Minute}, Layer{Store: remote, TTL: time.Minute})
+
+	l.Get("hello")
+	if _, ok := l.Get("hello"); ok || remote.gets.Load() != 1 {
+		t.Fatalf("expected the miss to be cached, got %d lookups", remote.gets.Load())
+	}
+
+	// Adding through the store drops the cached miss
+	if !l.Add("hello", testService) {
+		t.Fatal("expected hello to be added")
+	}
+	if _, ok := l.Get("hello"); !ok {
+		t.Fatal("expected hello to be found after Add")
+	}
+
+	// And so does removing
+	l.Remove("hello")
+	if _, ok := l.Get("hello"); ok {
+		t.Fatal("expected hello to be gone after Remove")
+	}
+}
+
+// A lookup racing with an invalidation must not cache the result it got before the change
+func TestLayeredGeneration(t *testing.T) {
+	remote := newCountingStore()
+	remote.Add("hello", testService)
+	l := NewLayeredCatalogStore(LayeredOptions{}, Layer{Store: remote, TTL: time.Minute})
+
+	remote.onGet = func() {
+		remote.onGet = nil
+		l.Remove("hello")
+	}
+
+	// The lookup still sees hello, but must not cache it past the Remove
+	l.Get("hello")
+	if _, ok := l.Get("hello"); ok {
+		t.Fatal("a result fetched before Remove was cached")
+	}
+	if n := remote.gets.Load(); n != 2 {
+		t.Fatalf("expected 2 lookups, got %d", n)
+	}
+}
+
+func TestLayeredPrecedence(t *testing.T) {
+	upper, lower := newCountingStore(), newCountingStore()
+	upper.Add("hello", ServiceInfo{Address: "upper", Socket: TCP})
+	lower.Add("hello", ServiceInfo{Address: "lower", Socket: TCP})
+	lower.Add("billing", ServiceInfo{Address: "lower", Socket: TCP})
+
+	l := NewLayeredCatalogStore(LayeredOptions{}, Layer{Store: upper}, Layer{Store: lower, ReadOnly: true})
+
+	if s, _ := l.Get("hello"); s.Address != "upper" {
+		t.Fatalf("expected the upper layer to take precedence, got %s", s.Address)
+	}
+	if s := l.List(); len(s) != 2 || s["hello"].Address != "upper" {
+		t.Fatalf("unexpected services %v", s)
+	}
+
+	// Read-only layers are not written to or removed from
+	l.Remove("billing")
+	if _, ok := lower.Get("billing"); !ok {
+		t.Fatal("expected billing to stay in the read-only layer")
+	}
+}
+
+func TestLayeredWritePolicies(t *testing.T) {
+	tests := []struct {
+		policy   WritePolicy
+		rejected []bool
+		ok       bool
+		added    []bool
+	}{
+		{WRITE_FIRST, []bool{true, false, false}, true, []bool{false, true, false}},
+		{WRITE_ANY, []bool{true, false, false}, true, []bool{false, true, true}},
+		{WRITE_ANY, []bool{true, true, true}, false, []bool{false, false, false}},
+		{WRITE_ALL, []bool{false, false, false}, true, []bool{true, true, true}},
+		{WRITE_ALL, []bool{false, false, true}, false, []bool{false, false, false}},
+	}
+
+	for _, tt := range tests {
+		var layers []Layer
+		var stores []*countingStore
+		for _, reject := range tt.rejected {
+			s := newCountingStore()
+			s.reject = reject
+			stores = append(stores, s)
+			layers = append(layers, Layer{Store: s})
+		}
+
+		l := NewLayeredCatalogStore(LayeredOptions{Write: tt.policy}, layers...)
+		if ok := l.Add("hello", testService); ok != tt.ok {
+			t.Fatalf("policy %d with rejections %v: expected Add to return %v", tt.policy, tt.rejected, tt.ok)
+		}
+		for i, s := range stores {
+			if _, ok := s.CatalogStore.Get("hello"); ok != tt.added[i] {
+				t.Fatalf("policy %d with rejections %v: expected layer %d to have hello: %v", tt.policy, tt.rejected, i, tt.added[i])
+			}
+		}
+	}
+
+	if NewLayeredCatalogStore(LayeredOptions{Write: WRITE_ALL}, Layer{Store: newCountingStore(), ReadOnly: true}).Add("hello", testService) {
+		t.Fatal("expected WRITE_ALL without writable layers to fail")
+	}
+}
+
+func TestLayeredWriteAllRollback(t *testing.T) {
+	previous := ServiceInfo{Address: "127.0.0.1:20000", Socket: TCP}
+	first, second, rejecting := newCountingStore(), newCountingStore(), newCountingStore()
+	first.Add("hello", previous)
+	rejecting.reject = true
+
+	l := NewLayeredCatalogStore(LayeredOptions{Write: WRITE_ALL}, Layer{Store: first}, Layer{Store: second}, Layer{Store: rejecting})
+	if l.Add("hello", testService) {
+		t.Fatal("expected WRITE_ALL to fail when a layer rejects the service")
+	}
+
+	if s, ok := first.CatalogStore.Get("hello"); !ok || s != previous {
+		t.Fatalf("expected the first layer to be rolled back to %v, got %v", previous, s)
+	}
+	if _, ok := second.CatalogStore.Get("hello"); ok {
+		t.Fatal("expected the service to be removed from the second layer")
+	}
+}
+
+func TestLayeredSkipsReadOnlyStores(t *testing.T) {
+	local := newCountingStore()
+	static := NewStaticCatalogStore(map[string]ServiceInfo{"billing": testService})
+
+	// The static layer is not flagged ReadOnly, its store is
+	l := NewLayeredCatalogStore(LayeredOptions{Write: WRITE_ALL}, Layer{Store: local}, Layer{Store: static})
+	if !l.Add("hello", testService) {
+		t.Fatal("expected WRITE_ALL to skip the read-only store")
+	}
+	if _, ok := local.CatalogStore.Get("hello"); !ok {
+		t.Fatal("expected hello in the writable layer")
+	}
+	if l.ReadOnly() {
+		t.Fatal("expected the store to be writable")
+	}
+
+	if NewLayeredCatalogStore(LayeredOptions{Write: WRITE_ALL}, Layer{Store: static}).Add("hello", testService) {
+		t.Fatal("expected WRITE_ALL without writable layers to fail")
+	}
+}
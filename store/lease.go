@@ -0,0 +1,140 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// LeaseCatalogStore expires services that are not registered again within a TTL.
+// Services in the underlying store that were not added through it never expire.
+type LeaseCatalogStore struct {
+	cs CatalogStore
+
+	mu     sync.Mutex
+	ttl    time.Duration
+	expiry map[string]time.Time
+
+	onExpire []func(name string)
+}
+
+// NewLeaseCatalogStore wraps cs so services added to it expire after ttl
+func NewLeaseCatalogStore(cs CatalogStore, ttl time.Duration) *LeaseCatalogStore {
+	return &LeaseCatalogStore{
+		cs:     cs,
+		ttl:    ttl,
+		expiry: make(map[string]time.Time),
+	}
+}
+
+// SetTTL changes the TTL of services added from now on
+func (l *LeaseCatalogStore) SetTTL(ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ttl = ttl
+}
+
+func (l *LeaseCatalogStore) Add(name string, s ServiceInfo) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.cs.Add(name, s) {
+		return false
+	}
+	l.expiry[name] = time.Now().Add(l.ttl)
+	return true
+}
+
+func (l *LeaseCatalogStore) Get(name string) (ServiceInfo, bool) {
+	if l.expired(name) {
+		return ServiceInfo{}, false
+	}
+	return l.cs.Get(name)
+}
+
+// List returns the services that have not expired, if the underlying store is a Lister
+func (l *LeaseCatalogStore) List() map[string]ServiceInfo {
+	lister, ok := l.cs.(Lister)
+	if !ok {
+		return nil
+	}
+
+	services := lister.List()
+	for name := range services {
+		if l.expired(name) {
+			delete(services, name)
+		}
+	}
+	return services
+}
+
+// Remove removes a service from the underlying store, if it is a Remover
+func (l *LeaseCatalogStore) Remove(name string) bool {
+	remover, ok := l.cs.(Remover)
+	if !ok {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.expiry, name)
+	return remover.Remove(name)
+}
+
+// ReadOnly reports whether the underlying store is read-only
+func (l *LeaseCatalogStore) ReadOnly() bool {
+	return IsReadOnly(l.cs)
+}
+
+// Len returns the number of services that have not expired
+func (l *LeaseCatalogStore) Len() int {
+	return len(l.List())
+}
+
+// OnExpire registers fn to be called with the name of every service removed by Expire
+func (l *LeaseCatalogStore) OnExpire(fn func(name string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onExpire = append(l.onExpire, fn)
+}
+
+// Expire removes the expired services from the underlying store and returns their names.
+// If the underlying store is not a Remover, expired services are only hidden from Get and List.
+func (l *LeaseCatalogStore) Expire() []string {
+	expired, callbacks := l.expire()
+	for _, name := range expired {
+		for _, fn := range callbacks {
+			fn(name)
+		}
+	}
+	return expired
+}
+
+func (l *LeaseCatalogStore) expire() ([]string, []func(name string)) {
+	remover, ok := l.cs.(Remover)
+	if !ok {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Removing under the lock keeps a service registered again meanwhile from being removed
+	now := time.Now()
+	var expired []string
+	for name, t := range l.expiry {
+		if now.After(t) {
+			expired = append(expired, name)
+			delete(l.expiry, name)
+			remover.Remove(name)
+		}
+	}
+	return expired, l.onExpire
+}
+
+// expired reports whether the lease of name has run out
+func (l *LeaseCatalogStore) expired(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.expiry[name]
+	return ok && time.Now().After(t)
+}
@@ -0,0 +1,58 @@
+package store
+
+// OverlayCatalogStore adds services to a writable upper store and looks them up there first,
+// falling back to the lower store. With a StaticCatalogStore as the lower store, static entries
+// act as defaults that dynamic registrations override.
+type OverlayCatalogStore struct {
+	upper CatalogStore
+	lower CatalogStore
+}
+
+// NewOverlayCatalogStore layers upper over lower
+func NewOverlayCatalogStore(upper, lower CatalogStore) *OverlayCatalogStore {
+	return &OverlayCatalogStore{upper: upper, lower: lower}
+}
+
+func (o *OverlayCatalogStore) Add(name string, s ServiceInfo) bool {
+	return o.upper.Add(name, s)
+}
+
+func (o *OverlayCatalogStore) Get(name string) (ServiceInfo, bool) {
+	if s, ok := o.upper.Get(name); ok {
+		return s, ok
+	}
+	return o.lower.Get(name)
+}
+
+// List returns the services of both stores that are Listers, the upper store taking precedence
+func (o *OverlayCatalogStore) List() map[string]ServiceInfo {
+	services := make(map[string]ServiceInfo)
+	for _, cs := range []CatalogStore{o.lower, o.upper} {
+		if lister, ok := cs.(Lister); ok {
+			for name, s := range lister.List() {
+				services[name] = s
+			}
+		}
+	}
+	return services
+}
+
+// Remove removes a service from the upper store, if it is a Remover.
+// A service of the lower store with the same name is visible again afterwards.
+func (o *OverlayCatalogStore) Remove(name string) bool {
+	remover, ok := o.upper.(Remover)
+	if !ok {
+		return false
+	}
+	return remover.Remove(name)
+}
+
+// ReadOnly reports whether the upper store is read-only
+func (o *OverlayCatalogStore) ReadOnly() bool {
+	return IsReadOnly(o.upper)
+}
+
+// Len returns the number of distinct services in both stores
+func (o *OverlayCatalogStore) Len() int {
+	return len(o.List())
+}
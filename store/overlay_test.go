@@ -0,0 +1,43 @@
+package store
+
+import "testing"
+
+func TestOverlayCatalogStore(t *testing.T) {
+	static := ServiceInfo{Address: "10.0.0.5:10000", Socket: TCP, Origin: "env"}
+	o := NewOverlayCatalogStore(NewMemCatalogStore(), NewStaticCatalogStore(map[string]ServiceInfo{"hello": static, "billing": static}))
+
+	if IsReadOnly(o) {
+		t.Fatal("expected the overlay of a writable store to accept registrations")
+	}
+	if s, ok := o.Get("hello"); !ok || s != static {
+		t.Fatalf("expected the static hello, got %v", s)
+	}
+
+	// Registrations override the static services until they are removed
+	if !o.Add("hello", testService) || !o.Add("world", testService) {
+		t.Fatal("could not add to the upper store")
+	}
+	if s, _ := o.Get("hello"); s != testService {
+		t.Fatalf("expected the registered hello, got %v", s)
+	}
+	if n := o.Len(); n != 3 {
+		t.Fatalf("expected 3 distinct services, got %d", n)
+	}
+	if s := o.List()["hello"]; s != testService {
+		t.Fatalf("expected List to prefer the registered hello, got %v", s)
+	}
+
+	if !o.Remove("hello") {
+		t.Fatal("could not remove hello")
+	}
+	if s, _ := o.Get("hello"); s != static {
+		t.Fatalf("expected the static hello again, got %v", s)
+	}
+	if o.Remove("billing") {
+		t.Fatal("expected a static service not to be removed")
+	}
+
+	if !IsReadOnly(NewOverlayCatalogStore(NewStaticCatalogStore(nil), NewMemCatalogStore())) {
+		t.Fatal("expected the overlay of a read-only store to be read-only")
+	}
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ENV_PREFIX is the prefix of the environment variables read by NewEnvCatalogStore.
+// PLUGIN_CATALOG_TOKEN, PLUGIN_CATALOG_IDENTITY and PLUGIN_CATALOG_KEY hold the catalog credentials of plugins and are not services.
+const ENV_PREFIX = "PLUGIN_CATALOG_"
+
+var reservedEnv = map[string]bool{"TOKEN": true, "IDENTITY": true, "KEY": true}
+
+// StaticCatalogStore is a read-only CatalogStore of services known up front,
+// loaded from a map, a YAML or JSON file, or environment variables
+type StaticCatalogStore struct {
+	mu       sync.RWMutex
+	services map[string]ServiceInfo
+
+	// path and the state of the file last seen are set for stores loaded from a file
+	path    string
+	modTime time.Time
+	size    int64
+	missing bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// StaticFile is the format of the files read by NewFileCatalogStore:
+//
+//	services:
+//	  hello:
+//	    address: 10.0.0.5:10000
+//	    socket: tcp
+//	  billing:
+//	    address: /run/plugins/billing.sock
+//	    socket: unix
+//
+// The socket defaults to tcp. JSON files use the same keys.
+type StaticFile struct {
+	Services map[string]StaticService `yaml:"services" json:"services"`
+}
+
+// StaticService is a service of a StaticFile
+type StaticService struct {
+	Address string     `yaml:"address" json:"address"`
+	Socket  SocketType `yaml:"socket" json:"socket"`
+}
+
+// FileOptions configures the reloading of a file store
+type FileOptions struct {
+	// ReloadInterval, if set, is how often the file is checked for changes
+	ReloadInterval time.Duration
+
+	// OnReload, if set, is called with the result of every reload after a change, nil on success.
+	// The previous services are kept if the file cannot be loaded.
+	OnReload func(err error)
+}
+
+// NewStaticCatalogStore returns a read-only store of services
+func NewStaticCatalogStore(services map[string]ServiceInfo) *StaticCatalogStore {
+	s := &StaticCatalogStore{
+		services: make(map[string]ServiceInfo, len(services)),
+		done:     make(chan struct{}),
+	}
+	for name, svc := range services {
+		s.services[name] = svc
+	}
+	return s
+}
+
+// NewFileCatalogStore returns a read-only store of the services in the YAML or JSON file at path.
+// With ReloadInterval set, the file is loaded again when it changes until Close is called.
+func NewFileCatalogStore(path string, opt FileOptions) (*StaticCatalogStore, error) {
+	s := NewStaticCatalogStore(nil)
+	s.path = path
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	if opt.ReloadInterval > 0 {
+		go s.watch(opt)
+	}
+	return s, nil
+}
+
+// NewEnvCatalogStore returns a read-only store of the services set in environ, in the form of os.Environ.
+// Every PLUGIN_CATALOG_<NAME> variable adds the service <name> in lower case, whose address is a host:port or
+// tcp://host:port for TCP, and an absolute path or unix:///path for unix sockets.
+func NewEnvCatalogStore(environ []string) (*StaticCatalogStore, error) {
+	services := make(map[string]ServiceInfo)
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(key, ENV_PREFIX)
+		if !ok || name == "" || reservedEnv[name] {
+			continue
+		}
+
+		svc, err := parseEnvService(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		services[strings.ToLower(name)] = svc
+	}
+
+	return NewStaticCatalogStore(services), nil
+}
+
+func parseEnvService(value string) (ServiceInfo, error) {
+	svc := ServiceInfo{Origin: "env"}
+	switch {
+	case strings.HasPrefix(value, "unix://"):
+		svc.Address, svc.Socket = strings.TrimPrefix(value, "unix://"), UNIX
+	case strings.HasPrefix(value, "tcp://"):
+		svc.Address, svc.Socket = strings.TrimPrefix(value, "tcp://"), TCP
+	case strings.HasPrefix(value, "/"):
+		svc.Address, svc.Socket = value, UNIX
+	default:
+		svc.Address, svc.Socket = value, TCP
+	}
+
+	if svc.Address == "" {
+		return svc, fmt.Errorf("address is empty")
+	}
+	return svc, nil
+}
+
+// Add does nothing, the store is read-only
+func (s *StaticCatalogStore) Add(name string, svc ServiceInfo) bool {
+	return false
+}
+
+// ReadOnly implements the ReadOnly interface, the store never accepts registrations
+func (s *StaticCatalogStore) ReadOnly() bool {
+	return true
+}
+
+func (s *StaticCatalogStore) Get(name string) (ServiceInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	svc, ok := s.services[name]
+	return svc, ok
+}
+
+// List returns a copy of the services in the store
+func (s *StaticCatalogStore) List() map[string]ServiceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	services := make(map[string]ServiceInfo, len(s.services))
+	for name, svc := range s.services {
+		services[name] = svc
+	}
+	return services
+}
+
+// Len returns the number of services in the store
+func (s *StaticCatalogStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.services)
+}
+
+// Reload loads the file of the store again, the services are left unchanged if it fails.
+// It does nothing for stores that were not loaded from a file.
+func (s *StaticCatalogStore) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read catalog file: %v", err)
+	}
+
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read catalog file: %v", err)
+	}
+
+	// YAML is a superset of JSON, so both are parsed as YAML
+	var f StaticFile
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return fmt.Errorf("could not parse catalog file %s: %v", s.path, err)
+	}
+
+	services := make(map[string]ServiceInfo, len(f.Services))
+	for name, svc := range f.Services {
+		if svc.Socket == "" {
+			svc.Socket = TCP
+		}
+		if svc.Socket != TCP && svc.Socket != UNIX {
+			return fmt.Errorf("service %s in %s has invalid socket type %q", name, s.path, svc.Socket)
+		}
+		if svc.Address == "" {
+			return fmt.Errorf("service %s in %s has no address", name, s.path)
+		}
+		services[name] = ServiceInfo{Address: svc.Address, Socket: svc.Socket, Origin: "file:" + s.path}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services = services
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	s.missing = false
+	return nil
+}
+
+// Close stops watching the file of the store
+func (s *StaticCatalogStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+// watch reloads the file when its modification time or size changes
+func (s *StaticCatalogStore) watch(opt FileOptions) {
+	ticker := time.NewTicker(opt.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !s.changed() {
+			continue
+		}
+
+		err := s.Reload()
+		if err != nil {
+			// Don't report the same broken file again until it changes
+			s.markSeen()
+		}
+		if opt.OnReload != nil {
+			opt.OnReload(err)
+		}
+	}
+}
+
+// changed reports whether the file differs from the one last seen, including when it goes missing
+func (s *StaticCatalogStore) changed() bool {
+	info, err := os.Stat(s.path)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err != nil {
+		return !s.missing
+	}
+	return s.missing || !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+// markSeen records the current state of the file, so it is only loaded again once it changes
+func (s *StaticCatalogStore) markSeen() {
+	info, err := os.Stat(s.path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.missing = true
+		return
+	}
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	s.missing = false
+}
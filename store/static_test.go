@@ -0,0 +1,162 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileCatalogStore(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"catalog.yaml", "services:\n  hello:\n    address: 10.0.0.5:10000\n  billing:\n    address: /run/plugins/billing.sock\n    socket: unix\n"},
+		{"catalog.json", `{"services": {"hello": {"address": "10.0.0.5:10000"}, "billing": {"address": "/run/plugins/billing.sock", "socket": "unix"}}}`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		writeFile(t, path, tt.content)
+
+		s, err := NewFileCatalogStore(path, FileOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if svc, _ := s.Get("hello"); svc != (ServiceInfo{Address: "10.0.0.5:10000", Socket: TCP, Origin: "file:" + path}) {
+			t.Fatalf("expected hello over tcp in %s, got %v", tt.name, svc)
+		}
+		if svc, _ := s.Get("billing"); svc.Address != "/run/plugins/billing.sock" || svc.Socket != UNIX {
+			t.Fatalf("expected billing over a unix socket in %s, got %v", tt.name, svc)
+		}
+		if s.Add("world", testService) || !IsReadOnly(s) {
+			t.Fatal("expected the store to be read-only")
+		}
+	}
+}
+
+func TestFileCatalogStoreInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for _, content := range []string{
+		"services:\n  hello:\n    address: 10.0.0.5:10000\n    port: 10000\n",
+		"services:\n  hello:\n    address: 10.0.0.5:10000\n    socket: udp\n",
+		"services:\n  hello:\n    socket: tcp\n",
+		"services: [",
+	} {
+		path := filepath.Join(dir, "catalog.yaml")
+		writeFile(t, path, content)
+		if _, err := NewFileCatalogStore(path, FileOptions{}); err == nil {
+			t.Fatalf("expected %q to be rejected", content)
+		}
+	}
+
+	if _, err := NewFileCatalogStore(filepath.Join(dir, "missing.yaml"), FileOptions{}); err == nil {
+		t.Fatal("expected a missing file to be rejected")
+	}
+}
+
+func TestFileCatalogStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.yaml")
+	writeFile(t, path, "services:\n  hello:\n    address: 10.0.0.5:10000\n")
+
+	reloads := make(chan error, 10)
+	s, err := NewFileCatalogStore(path, FileOptions{ReloadInterval: 10 * time.Millisecond, OnReload: func(err error) { reloads <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	wait := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the file was not reloaded")
+			return nil
+		}
+	}
+
+	// The previous services are kept while the file is broken or missing
+	writeFile(t, path, "services: [")
+	if err := wait(); err == nil {
+		t.Fatal("expected the broken file to fail to load")
+	}
+	if _, ok := s.Get("hello"); !ok {
+		t.Fatal("expected hello to be kept")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err == nil {
+		t.Fatal("expected the missing file to fail to load")
+	}
+	if _, ok := s.Get("hello"); !ok {
+		t.Fatal("expected hello to be kept")
+	}
+
+	writeFile(t, path, "services:\n  world:\n    address: 10.0.0.6:10000\n")
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("hello"); ok {
+		t.Fatal("expected hello to be gone")
+	}
+	if svc, _ := s.Get("world"); svc.Address != "10.0.0.6:10000" {
+		t.Fatalf("expected world, got %v", svc)
+	}
+
+	select {
+	case err := <-reloads:
+		t.Fatalf("expected a single reload per change, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEnvCatalogStore(t *testing.T) {
+	s, err := NewEnvCatalogStore([]string{
+		"PLUGIN_CATALOG_HELLO=10.0.0.5:10000",
+		"PLUGIN_CATALOG_WORLD=tcp://10.0.0.6:10000",
+		"PLUGIN_CATALOG_BILLING=unix:///run/plugins/billing.sock",
+		"PLUGIN_CATALOG_ORDERS=/run/plugins/orders.sock",
+		"PLUGIN_CATALOG_TOKEN=secret",
+		"PLUGIN_CATALOG_IDENTITY=ops",
+		"PLUGIN_CATALOG_KEY=key",
+		"PLUGIN_CATALOG_=10.0.0.7:10000",
+		"PATH=/usr/bin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]ServiceInfo{
+		"hello":   {Address: "10.0.0.5:10000", Socket: TCP, Origin: "env"},
+		"world":   {Address: "10.0.0.6:10000", Socket: TCP, Origin: "env"},
+		"billing": {Address: "/run/plugins/billing.sock", Socket: UNIX, Origin: "env"},
+		"orders":  {Address: "/run/plugins/orders.sock", Socket: UNIX, Origin: "env"},
+	}
+	services := s.List()
+	if len(services) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, services)
+	}
+	for name, svc := range expected {
+		if services[name] != svc {
+			t.Fatalf("expected %s to be %v, got %v", name, svc, services[name])
+		}
+	}
+
+	for _, value := range []string{"", "unix://", "tcp://"} {
+		if _, err := NewEnvCatalogStore([]string{"PLUGIN_CATALOG_HELLO=" + value}); err == nil {
+			t.Fatalf("expected the empty address %q to be rejected", value)
+		}
+	}
+}
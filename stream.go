@@ -0,0 +1,217 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/cvhariharan/plugin/protogen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DEFAULT_CHUNK_SIZE is the maximum number of bytes sent in a single Chunk.
+// It is kept well below the default gRPC message size limit of 4MB.
+const DEFAULT_CHUNK_SIZE = 32 * 1024
+
+// ChunkSender is implemented by the client and server side of any gRPC stream that sends Chunks
+type ChunkSender interface {
+	Send(*protogen.Chunk) error
+}
+
+// ChunkReceiver is implemented by the client and server side of any gRPC stream that receives Chunks
+type ChunkReceiver interface {
+	Recv() (*protogen.Chunk, error)
+}
+
+// StreamWriter writes data to a stream in chunks of at most ChunkSize bytes.
+// Flow control is provided by gRPC, Write blocks while the receiver is not reading.
+type StreamWriter struct {
+	s         ChunkSender
+	ChunkSize int
+}
+
+// NewStreamWriter returns a StreamWriter that sends data on s using DEFAULT_CHUNK_SIZE
+func NewStreamWriter(s ChunkSender) *StreamWriter {
+	return &StreamWriter{s: s, ChunkSize: DEFAULT_CHUNK_SIZE}
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	size := w.ChunkSize
+	if size <= 0 {
+		size = DEFAULT_CHUNK_SIZE
+	}
+
+	var n int
+	for n < len(p) {
+		end := min(n+size, len(p))
+		if err := w.s.Send(&protogen.Chunk{Data: p[n:end]}); err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+// Close ends the stream. On the server side of a stream this is a no-op, the stream ends when the handler returns.
+func (w *StreamWriter) Close() error {
+	if cs, ok := w.s.(interface{ CloseSend() error }); ok {
+		return cs.CloseSend()
+	}
+	return nil
+}
+
+// CloseWithError sends err to the reader, which returns it from Read instead of io.EOF, and ends the stream
+func (w *StreamWriter) CloseWithError(err error) error {
+	if err == nil {
+		return w.Close()
+	}
+
+	code := status.Code(err)
+	if code == codes.Unknown {
+		code = status.FromContextError(err).Code()
+	}
+
+	if sendErr := w.s.Send(&protogen.Chunk{Error: errorDetail(err, code)}); sendErr != nil {
+		return sendErr
+	}
+	return w.Close()
+}
+
+// StreamReader reads the data sent by a StreamWriter.
+// Read returns io.EOF once the sender closes the stream, or the error sent with CloseWithError.
+type StreamReader struct {
+	s   ChunkReceiver
+	buf []byte
+	err error
+}
+
+// NewStreamReader returns a StreamReader that reads data from s
+func NewStreamReader(s ChunkReceiver) *StreamReader {
+	return &StreamReader{s: s}
+}
+
+func (r *StreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		c, err := r.s.Recv()
+		if err != nil {
+			r.err = FromStatus(err)
+			return 0, r.err
+		}
+
+		if c.Error != nil {
+			r.err = chunkError(c.Error)
+			return 0, r.err
+		}
+		r.buf = c.Data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// SendReader copies r to s until r returns io.EOF.
+// Any other read error is sent to the receiver with CloseWithError and returned.
+func SendReader(s ChunkSender, r io.Reader) error {
+	w := NewStreamWriter(s)
+	if _, err := io.Copy(w, r); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+// SendChan sends every value received from ch on s until ch is closed or ctx is done.
+// Values are encoded with the TypeRegistry.
+func SendChan[T any](ctx context.Context, s ChunkSender, ch <-chan T) error {
+	w := &StreamWriter{s: s}
+	for {
+		select {
+		case <-ctx.Done():
+			w.CloseWithError(ctx.Err())
+			return ctx.Err()
+
+		case v, ok := <-ch:
+			if !ok {
+				return w.Close()
+			}
+
+			val, err := encodeValue(reflect.ValueOf(&v).Elem())
+			if err != nil {
+				err = fmt.Errorf("could not encode channel value: %v", err)
+				w.CloseWithError(err)
+				return err
+			}
+
+			if err := s.Send(&protogen.Chunk{Value: val}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RecvChan receives the values sent with SendChan.
+// The value channel is closed when the stream ends. If it ended because of an error or
+// because ctx is done, the error is sent on the error channel before both channels are closed.
+// Values are only received from the stream as fast as they are read from the returned channel.
+func RecvChan[T any](ctx context.Context, s ChunkReceiver) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errc := make(chan error, 1)
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	go func() {
+		defer close(errc)
+		defer close(out)
+
+		for {
+			c, err := s.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- FromStatus(err)
+				return
+			}
+
+			if c.Error != nil {
+				errc <- chunkError(c.Error)
+				return
+			}
+
+			if c.Value == nil {
+				continue
+			}
+
+			v, err := decodeArg(c.Value, t)
+			if err != nil {
+				errc <- fmt.Errorf("could not decode channel value: %v", err)
+				return
+			}
+
+			var val T
+			if i := v.Interface(); i != nil {
+				val = i.(T)
+			}
+
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return out, errc
+}
+
+// chunkError reconstructs an error sent with CloseWithError
+func chunkError(detail *protogen.ErrorDetail) error {
+	return fromErrorDetail(status.New(codes.Code(detail.Code), detail.Message), detail)
+}
@@ -0,0 +1,149 @@
+package plugin_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/cvhariharan/plugin"
+	"github.com/cvhariharan/plugin/protogen"
+)
+
+// chunkPipe connects a ChunkSender to a ChunkReceiver and records the size of every chunk sent
+type chunkPipe struct {
+	ch    chan *protogen.Chunk
+	sizes []int
+}
+
+func newChunkPipe() *chunkPipe {
+	return &chunkPipe{ch: make(chan *protogen.Chunk, 1024)}
+}
+
+func (p *chunkPipe) Send(c *protogen.Chunk) error {
+	p.sizes = append(p.sizes, len(c.Data))
+	p.ch <- c
+	return nil
+}
+
+func (p *chunkPipe) CloseSend() error {
+	close(p.ch)
+	return nil
+}
+
+func (p *chunkPipe) Recv() (*protogen.Chunk, error) {
+	c, ok := <-p.ch
+	if !ok {
+		return nil, io.EOF
+	}
+	return c, nil
+}
+
+func TestStreamWriterChunkSize(t *testing.T) {
+	p := newChunkPipe()
+	w := plugin.NewStreamWriter(p)
+	w.ChunkSize = 10
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 6)
+	if n, err := w.Write(data); err != nil || n != len(data) {
+		t.Fatalf("wrote %d bytes, %v", n, err)
+	}
+	w.Close()
+
+	if len(p.sizes) != 10 {
+		t.Fatalf("expected 10 chunks, got %d", len(p.sizes))
+	}
+	for _, size := range p.sizes {
+		if size > 10 {
+			t.Fatalf("chunk of %d bytes exceeds the chunk size", size)
+		}
+	}
+
+	got, err := io.ReadAll(plugin.NewStreamReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data read differs from data written")
+	}
+}
+
+func TestSendReader(t *testing.T) {
+	data := make([]byte, 5*plugin.DEFAULT_CHUNK_SIZE+123)
+	rand.Read(data)
+
+	p := newChunkPipe()
+	if err := plugin.SendReader(p, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.sizes) != 6 || p.sizes[0] != plugin.DEFAULT_CHUNK_SIZE || p.sizes[5] != 123 {
+		t.Fatalf("unexpected chunk sizes %v", p.sizes)
+	}
+
+	// Small reads have to consume a chunk over several calls
+	var got bytes.Buffer
+	r := plugin.NewStreamReader(p)
+	buf := make([]byte, 1000)
+	for {
+		n, err := r.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatal("data read differs from data sent")
+	}
+}
+
+func TestStreamCloseWithError(t *testing.T) {
+	p := newChunkPipe()
+	w := plugin.NewStreamWriter(p)
+	w.Write([]byte("partial"))
+	w.CloseWithError(ErrFrozen)
+
+	r := plugin.NewStreamReader(p)
+	got, err := io.ReadAll(r)
+	if string(got) != "partial" {
+		t.Fatalf("expected the data sent before the error, got %q", got)
+	}
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("expected ErrFrozen, got %v", err)
+	}
+
+	// The error is sticky
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("expected ErrFrozen again, got %v", err)
+	}
+}
+
+func TestSendRecvChan(t *testing.T) {
+	p := newChunkPipe()
+	in := make(chan Account, 3)
+	in <- Account{Name: "alice", Balance: 1}
+	in <- Account{Name: "bob", Balance: 2}
+	in <- Account{Name: "carol", Balance: 3}
+	close(in)
+
+	if err := plugin.SendChan(context.Background(), p, in); err != nil {
+		t.Fatal(err)
+	}
+
+	out, errc := plugin.RecvChan[Account](context.Background(), p)
+	var names []string
+	for a := range out {
+		names = append(names, a.Name)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || names[0] != "alice" || names[2] != "carol" {
+		t.Fatalf("unexpected values %v", names)
+	}
+}
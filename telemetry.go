@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"context"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+)
+
+const instrumentationName = "github.com/cvhariharan/plugin"
+
+// propagator is used on every plugin connection so trace context is
+// propagated even if no global propagator has been set
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// telemetry holds the tracer and metric instruments used by Load and Serve.
+// If no providers are given, the global OpenTelemetry providers are used.
+type telemetry struct {
+	tp trace.TracerProvider
+	mp metric.MeterProvider
+
+	tracer     trace.Tracer
+	startTime  metric.Float64Histogram
+	loadErrors metric.Int64Counter
+	restarts   metric.Int64Counter
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+
+	t := &telemetry{
+		tp:     tp,
+		mp:     mp,
+		tracer: tp.Tracer(instrumentationName),
+	}
+
+	// Instrument creation only fails for invalid names, in which case a no-op instrument is returned
+	meter := mp.Meter(instrumentationName)
+	t.startTime, _ = meter.Float64Histogram("plugin.start.duration",
+		metric.WithDescription("Time taken to launch a plugin process and connect to it"),
+		metric.WithUnit("s"),
+	)
+	t.loadErrors, _ = meter.Int64Counter("plugin.load.errors",
+		metric.WithDescription("Number of plugins that failed to load"),
+	)
+	t.restarts, _ = meter.Int64Counter("plugin.restarts",
+		metric.WithDescription("Number of times a plugin was restarted"),
+	)
+
+	return t
+}
+
+// startSpan starts a span as a child of ctx
+func (t *telemetry) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// clientHandler returns the stats handler that traces and measures calls made to a plugin
+func (t *telemetry) clientHandler() stats.Handler {
+	return otelgrpc.NewClientHandler(
+		otelgrpc.WithTracerProvider(t.tp),
+		otelgrpc.WithMeterProvider(t.mp),
+		otelgrpc.WithPropagators(propagator),
+	)
+}
+
+// serverHandler returns the stats handler that traces and measures calls served by a plugin
+func (t *telemetry) serverHandler() stats.Handler {
+	return otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(t.tp),
+		otelgrpc.WithMeterProvider(t.mp),
+		otelgrpc.WithPropagators(propagator),
+	)
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// verifyBinary checks the plugin executable against the checksum and public key in opt before it is launched,
+// and returns the contents that were verified, nil if opt does not ask for verification.
+// With a public key, the detached signature is read from opt.SignaturePath, or from the path of the
+// executable with a .minisig extension for minisign keys and a .sig extension for raw ed25519 keys.
+func verifyBinary(opt PluginLoadOptions) ([]byte, error) {
+	if opt.Checksum == "" && opt.PublicKey == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(opt.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read plugin %s for verification: %v", opt.Path, err)
+	}
+
+	if opt.Checksum != "" {
+		if err := verifyChecksum(data, opt.Checksum); err != nil {
+			return nil, fmt.Errorf("plugin %s failed checksum verification: %v", opt.Path, err)
+		}
+	}
+
+	if opt.PublicKey != "" {
+		key, err := parsePublicKey(opt.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %v", err)
+		}
+
+		sigPath := opt.SignaturePath
+		if sigPath == "" {
+			sigPath = opt.Path + ".sig"
+			if key.minisign {
+				sigPath = opt.Path + ".minisig"
+			}
+		}
+
+		sig, err := os.ReadFile(sigPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read signature of plugin %s: %v", opt.Path, err)
+		}
+
+		if err := key.verify(data, sig); err != nil {
+			return nil, fmt.Errorf("plugin %s failed signature verification: %v", opt.Path, err)
+		}
+	}
+
+	return data, nil
+}
+
+// copyVerifiedBinary writes data, the verified contents of the plugin executable, to a new private directory
+// in opt.ExecDir and returns the directory and the path of the copy. Executing the copy instead of opt.Path
+// ensures the process runs what was verified, even if opt.Path is replaced in the meantime.
+func copyVerifiedBinary(opt PluginLoadOptions, data []byte) (string, string, error) {
+	dir, err := os.MkdirTemp(opt.ExecDir, "plugin-exec-")
+	if err != nil {
+		return "", "", fmt.Errorf("could not create directory for verified plugin %s: %v", opt.Path, err)
+	}
+
+	// A plugin running as another user has to be able to execute the copy, but not to replace it
+	dirMode, fileMode := os.FileMode(0700), os.FileMode(0500)
+	if opt.Sandbox != nil && opt.Sandbox.Credential != nil && !opt.Sandbox.NewUserNamespace {
+		dirMode, fileMode = 0711, 0555
+	}
+
+	path := filepath.Join(dir, filepath.Base(opt.Path))
+	if err := os.WriteFile(path, data, fileMode); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("could not copy verified plugin %s: %v", opt.Path, err)
+	}
+	if err := os.Chmod(path, fileMode); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("could not copy verified plugin %s: %v", opt.Path, err)
+	}
+	if err := os.Chmod(dir, dirMode); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("could not copy verified plugin %s: %v", opt.Path, err)
+	}
+
+	return dir, path, nil
+}
+
+// verifyChecksum compares the SHA-256 of data with the hex encoded checksum
+func verifyChecksum(data []byte, checksum string) error {
+	expected, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(checksum), "sha256:"))
+	if err != nil || len(expected) != sha256.Size {
+		return fmt.Errorf("checksum must be a hex encoded SHA-256")
+	}
+
+	actual := sha256.Sum256(data)
+	if subtle.ConstantTimeCompare(actual[:], expected) != 1 {
+		return fmt.Errorf("expected sha256 %x, got %x", expected, actual)
+	}
+	return nil
+}
+
+// publicKey is either a raw ed25519 key or a minisign key, which also carries a key ID
+type publicKey struct {
+	key      ed25519.PublicKey
+	keyID    []byte
+	minisign bool
+}
+
+// parsePublicKey parses a base64 encoded raw ed25519 public key or a minisign public key.
+// Minisign keys can be given as the contents of the .pub file, including the comment line.
+func parsePublicKey(s string) (*publicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(lastLine(s))
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64 encoded: %v", err)
+	}
+
+	switch {
+	case len(b) == ed25519.PublicKeySize:
+		return &publicKey{key: ed25519.PublicKey(b)}, nil
+
+	case len(b) == 42 && string(b[:2]) == "Ed":
+		return &publicKey{key: ed25519.PublicKey(b[10:]), keyID: b[2:10], minisign: true}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key format")
+}
+
+// verify checks sig, a detached signature of data
+func (k *publicKey) verify(data, sig []byte) error {
+	if !k.minisign {
+		return verifyEd25519(k.key, data, sig)
+	}
+	return k.verifyMinisign(data, sig)
+}
+
+// verifyEd25519 accepts the signature either as raw bytes or base64 encoded
+func verifyEd25519(key ed25519.PublicKey, data, sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil {
+			return fmt.Errorf("signature is neither raw nor base64 encoded")
+		}
+		sig = decoded
+	}
+
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// verifyMinisign verifies a minisign signature file, made of an untrusted comment,
+// the signature, a trusted comment and a global signature over the signature and trusted comment
+func (k *publicKey) verifyMinisign(data, file []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(file)), "\n")
+	if len(lines) != 4 {
+		return fmt.Errorf("malformed minisign signature")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 74 {
+		return fmt.Errorf("malformed minisign signature")
+	}
+
+	algorithm, keyID, signature := string(sig[:2]), sig[2:10], sig[10:]
+	if !bytes.Equal(keyID, k.keyID) {
+		return fmt.Errorf("signature was made with key %X, expected %X", reverse(keyID), reverse(k.keyID))
+	}
+
+	// "ED" signatures are made over the BLAKE2b-512 hash of the file, "Ed" over the file itself
+	message := data
+	switch algorithm {
+	case "ED":
+		h := blake2b.Sum512(data)
+		message = h[:]
+	case "Ed":
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", algorithm)
+	}
+
+	if !ed25519.Verify(k.key, message, signature) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	trustedComment, ok := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ok {
+		return fmt.Errorf("malformed minisign trusted comment")
+	}
+
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return fmt.Errorf("malformed minisign global signature")
+	}
+
+	signed := append(append([]byte{}, signature...), trustedComment...)
+	if !ed25519.Verify(k.key, signed, globalSig) {
+		return fmt.Errorf("invalid global signature")
+	}
+	return nil
+}
+
+// lastLine returns the last non empty line of s, skipping the comment line of minisign key files
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// reverse returns b in reverse order, minisign key IDs are little endian but displayed big endian
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
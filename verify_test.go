@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// writeBinary writes a fake plugin executable to a temporary directory
+func writeBinary(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(path, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("plugin binary")
+	path := writeBinary(t, data)
+	sum := sha256.Sum256(data)
+
+	for _, checksum := range []string{hex.EncodeToString(sum[:]), "sha256:" + strings.ToUpper(hex.EncodeToString(sum[:]))} {
+		if _, err := verifyBinary(PluginLoadOptions{Path: path, Checksum: checksum}); err != nil {
+			t.Fatalf("checksum %s: %v", checksum, err)
+		}
+	}
+
+	other := sha256.Sum256([]byte("other binary"))
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, Checksum: hex.EncodeToString(other[:])}); err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, Checksum: "not hex"}); err == nil {
+		t.Fatal("expected an invalid checksum to be rejected")
+	}
+}
+
+func TestVerifyEd25519(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	data := []byte("plugin binary")
+	path := writeBinary(t, data)
+	sig := ed25519.Sign(priv, data)
+
+	// Raw signature next to the binary
+	os.WriteFile(path+".sig", sig, 0644)
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Base64 signature at a custom path
+	sigPath := filepath.Join(t.TempDir(), "signature")
+	os.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644)
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key, SignaturePath: sigPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tampered binary
+	os.WriteFile(path, []byte("tampered binary"), 0755)
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err == nil {
+		t.Fatal("expected a tampered binary to be rejected")
+	}
+
+	// Missing signature
+	os.Remove(path + ".sig")
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err == nil {
+		t.Fatal("expected a missing signature to be rejected")
+	}
+}
+
+// minisignKey returns a minisign public key file for pub with keyID
+func minisignKey(pub ed25519.PublicKey, keyID []byte) string {
+	b := append(append([]byte("Ed"), keyID...), pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(b) + "\n"
+}
+
+// minisignSignature returns a minisign signature file of data made with algorithm, "ED" for prehashed signatures
+func minisignSignature(priv ed25519.PrivateKey, keyID []byte, algorithm string, data []byte, trustedComment string) string {
+	message := data
+	if algorithm == "ED" {
+		h := blake2b.Sum512(data)
+		message = h[:]
+	}
+
+	signature := ed25519.Sign(priv, message)
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, signature...), trustedComment...))
+	sig := append(append([]byte(algorithm), keyID...), signature...)
+
+	return "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(sig) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n"
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	key := minisignKey(pub, keyID)
+
+	data := []byte("plugin binary")
+	path := writeBinary(t, data)
+
+	for _, algorithm := range []string{"ED", "Ed"} {
+		os.WriteFile(path+".minisig", []byte(minisignSignature(priv, keyID, algorithm, data, "timestamp:1700000000")), 0644)
+		if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err != nil {
+			t.Fatalf("%s signature: %v", algorithm, err)
+		}
+	}
+
+	// Signature made with another key ID
+	os.WriteFile(path+".minisig", []byte(minisignSignature(priv, []byte{8, 7, 6, 5, 4, 3, 2, 1}, "ED", data, "timestamp:1700000000")), 0644)
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err == nil || !strings.Contains(err.Error(), "0807060504030201") {
+		t.Fatalf("expected a key ID mismatch, got %v", err)
+	}
+
+	// Trusted comment changed after signing
+	sig := minisignSignature(priv, keyID, "ED", data, "timestamp:1700000000")
+	os.WriteFile(path+".minisig", []byte(strings.Replace(sig, "1700000000", "1800000000", 1)), 0644)
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err == nil || !strings.Contains(err.Error(), "global signature") {
+		t.Fatalf("expected the global signature to fail, got %v", err)
+	}
+
+	// Tampered binary
+	os.WriteFile(path+".minisig", []byte(sig), 0644)
+	os.WriteFile(path, []byte("tampered binary"), 0755)
+	if _, err := verifyBinary(PluginLoadOptions{Path: path, PublicKey: key}); err == nil {
+		t.Fatal("expected a tampered binary to be rejected")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := parsePublicKey(key); err == nil {
+			t.Fatalf("expected %q to be rejected", key)
+		}
+	}
+}
+
+func TestCopyVerifiedBinary(t *testing.T) {
+	data := []byte("plugin binary")
+	path := writeBinary(t, data)
+
+	dir, copied, err := copyVerifiedBinary(PluginLoadOptions{Path: path, ExecDir: t.TempDir()}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeExecDir(dir)
+
+	// Replacing the original after verification does not change what is executed
+	os.WriteFile(path, []byte("tampered binary"), 0755)
+	if got, _ := os.ReadFile(copied); string(got) != string(data) {
+		t.Fatalf("copy holds %q", got)
+	}
+
+	for p, mode := range map[string]os.FileMode{dir: 0700, copied: 0500} {
+		if fi, err := os.Stat(p); err != nil || fi.Mode().Perm() != mode {
+			t.Fatalf("expected %s to have mode %o, got %v", p, mode, fi.Mode().Perm())
+		}
+	}
+}